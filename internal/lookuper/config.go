@@ -2,13 +2,20 @@ package lookuper
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"path"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ghodss/yaml"
+	"github.com/miekg/dns"
 	"github.com/pabateman/dns-lookuper/internal/printer"
-	"github.com/pabateman/dns-lookuper/internal/resolver/v2"
+	"github.com/pabateman/dns-lookuper/internal/resolver"
+	v1 "github.com/pabateman/dns-lookuper/internal/resolver/v1"
+	v2 "github.com/pabateman/dns-lookuper/internal/resolver/v2"
 	cli "github.com/urfave/cli/v2"
 )
 
@@ -25,14 +32,46 @@ const (
 	argInterval       = "interval"
 	argTimeout        = "timeout"
 	argFail           = "fail"
+	argConcurrency    = "concurrency"
+	argMaxInflight    = "max-inflight"
+	argServer         = "server"
+	argTransport      = "transport"
+	argAttempts       = "attempts"
+	argNoSearch       = "no-search"
+	argNoCache        = "no-cache"
+	argRateLimit      = "rate-limit"
+	argIterative      = "iterative"
+	argBackend        = "backend"
+	argRcode          = "rcode"
+	argTaskTimeout    = "task-timeout"
+	argStream         = "stream"
+	argWildcard       = "wildcard"
+	argSubnet         = "subnet"
+	argSubnetMode     = "subnet-mode"
+	argSamples        = "samples"
+	argSampleInterval = "sample-interval"
+	argSampleServers  = "sample-servers"
+	argDNSSEC         = "dnssec"
+	argTrustAnchor    = "trust-anchor"
 )
 
 const (
 	daemonEnabledDefault  = false
 	daemonIntervalDefault = "1m"
-	timeoutDefault        = resolver.TimeoutDefault
+	timeoutDefault        = v2.TimeoutDefault
 	formatDefault         = printer.FormatDefault
-	modeDefault           = resolver.ModeDefault
+	modeDefault           = v2.ModeDefault
+	concurrencyDefault    = v2.ConcurrencyDefault
+	maxInflightDefault    = v2.MaxInflightDefault
+	transportDefault      = v2.TransportDefault
+	backendDefault        = resolver.BackendDefault
+	subnetModeDefault     = subnetModeBreakdown
+	dnssecDefault         = v2.DNSSECDefault
+)
+
+const (
+	subnetModeBreakdown = "breakdown"
+	subnetModeUnion     = "union"
 )
 
 type config struct {
@@ -43,9 +82,27 @@ type config struct {
 type settings struct {
 	dir            string
 	outputConsole  bool
-	LookupTimeout  string          `json:"lookupTimeout"`
-	Fail           bool            `json:"fail"`
-	DaemonSettings *daemonSettings `json:"daemon"`
+	cache          *v2.Cache
+	trustAnchors   []dns.RR
+	LookupTimeout  string             `json:"lookupTimeout"`
+	TaskTimeout    string             `json:"taskTimeout"`
+	Fail           bool               `json:"fail"`
+	Concurrency    int                `json:"concurrency"`
+	MaxInflight    int                `json:"maxInflight"`
+	Servers        []string           `json:"servers"`
+	Attempts       int                `json:"attempts"`
+	Rotate         bool               `json:"rotate"`
+	Backoff        string             `json:"backoff"`
+	Transport      string             `json:"transport"`
+	TLS            *tlsSettings       `json:"tls"`
+	DoH            *dohSettings       `json:"doh"`
+	Cache          *cacheSettings     `json:"cache"`
+	RateLimit      *rateLimitSettings `json:"rateLimit"`
+	RootHints      []string           `json:"rootHints"`
+	MaxDepth       int                `json:"maxDepth"`
+	Rcodes         map[string]string  `json:"rcodes"`
+	TrustAnchors   []string           `json:"trustAnchors"`
+	DaemonSettings *daemonSettings    `json:"daemon"`
 }
 
 type daemonSettings struct {
@@ -53,12 +110,54 @@ type daemonSettings struct {
 	Interval string `json:"interval"`
 }
 
+type tlsSettings struct {
+	ServerName string   `json:"serverName"`
+	CAFile     string   `json:"caFile"`
+	SPKIPins   []string `json:"spkiPins"`
+}
+
+type dohSettings struct {
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+}
+
+type cacheSettings struct {
+	Enabled *bool  `json:"enabled"`
+	MinTTL  string `json:"minTTL"`
+	MaxTTL  string `json:"maxTTL"`
+}
+
+type rateLimitSettings struct {
+	QPS   float64 `json:"qps"`
+	Burst int     `json:"burst"`
+}
+
 type task struct {
-	Files    []string          `json:"files"`
-	Output   string            `json:"output"`
-	Mode     string            `json:"mode"`
-	Format   string            `json:"format"`
-	Template *printer.Template `json:"template"`
+	Backend          string             `json:"backend"`
+	Files            []string           `json:"files"`
+	Output           string             `json:"output"`
+	Mode             string             `json:"mode"`
+	Types            []string           `json:"types"`
+	Format           string             `json:"format"`
+	Template         *printer.Template  `json:"template"`
+	Servers          []string           `json:"servers"`
+	Transport        string             `json:"transport"`
+	TLS              *tlsSettings       `json:"tls"`
+	DoH              *dohSettings       `json:"doh"`
+	Search           *bool              `json:"search"`
+	Cache            *bool              `json:"cache"`
+	RateLimit        *rateLimitSettings `json:"rateLimit"`
+	Iterative        bool               `json:"iterative"`
+	Compare          []string           `json:"compare"`
+	CompareThreshold string             `json:"compareThreshold"`
+	Stream           bool               `json:"stream"`
+	Wildcard         string             `json:"wildcard"`
+	Subnets          []string           `json:"subnets"`
+	SubnetMode       string             `json:"subnetMode"`
+	Samples          int                `json:"samples"`
+	SampleInterval   string             `json:"sampleInterval"`
+	SampleServers    bool               `json:"sampleServers"`
+	DNSSEC           string             `json:"dnssec"`
 }
 
 var (
@@ -78,7 +177,7 @@ var (
 		},
 		&cli.StringFlag{
 			Name:    argMode,
-			Usage:   fmt.Sprintf("accept one of values: '%s' or '%s'", resolver.ModeIpv4, resolver.ModeIpv6),
+			Usage:   fmt.Sprintf("accept one of values: '%s', '%s', '%s', '%s' for reverse lookups of addresses or a record type like 'mx', 'txt', 'srv'", v2.ModeIpv4, v2.ModeIpv6, v2.ModeAll, v2.ModePTR),
 			Aliases: []string{"m"},
 			EnvVars: []string{"DNS_LOOKUPER_MODE"},
 			Value:   modeDefault,
@@ -128,10 +227,16 @@ var (
 		},
 		&cli.DurationFlag{
 			Name:    argTimeout,
-			Usage:   "lookup timeout in duration format like 1m, 5y, 15s etc",
+			Usage:   "timeout of a single query in duration format like 1m, 5y, 15s etc",
 			Aliases: []string{"w"},
 			EnvVars: []string{"DNS_LOOKUPER_TIMEOUT"},
-			Value:   timeoutDefault,
+			// The timeout is only applied when set, see newConfig.
+			DefaultText: fmt.Sprintf("%s with servers set, the timeout option of /etc/resolv.conf or 5s otherwise", timeoutDefault),
+		},
+		&cli.DurationFlag{
+			Name:    argTaskTimeout,
+			Usage:   "overall deadline of a task in duration format like 1m, 5y, 15s etc; the task fails with no output when names are not resolved in time; 0 means no deadline",
+			EnvVars: []string{"DNS_LOOKUPER_TASK_TIMEOUT"},
 		},
 		&cli.BoolFlag{
 			Name:    argFail,
@@ -139,20 +244,184 @@ var (
 			EnvVars: []string{"DNS_LOOKUPER_FAIL"},
 			Value:   false,
 		},
+		&cli.IntFlag{
+			Name:    argConcurrency,
+			Usage:   "number of domain names resolved in parallel",
+			EnvVars: []string{"DNS_LOOKUPER_CONCURRENCY"},
+			Value:   concurrencyDefault,
+		},
+		&cli.IntFlag{
+			Name:    argMaxInflight,
+			Usage:   "max number of queries in flight to a single upstream server; 0 means no limit",
+			EnvVars: []string{"DNS_LOOKUPER_MAX_INFLIGHT"},
+			Value:   maxInflightDefault,
+		},
+		&cli.StringSliceFlag{
+			Name:    argServer,
+			Usage:   "upstream DNS server as host, host:port or [ipv6]:port; nameservers from /etc/resolv.conf are used by default",
+			Aliases: []string{"s"},
+			EnvVars: []string{"DNS_LOOKUPER_SERVERS"},
+		},
+		&cli.StringFlag{
+			Name:    argTransport,
+			Usage:   fmt.Sprintf("query transport; accepted values are: %s; truncated udp responses are always repeated over tcp", transportEnum),
+			EnvVars: []string{"DNS_LOOKUPER_TRANSPORT"},
+			Value:   transportDefault,
+		},
+		&cli.IntFlag{
+			Name:    argAttempts,
+			Usage:   "number of passes over the server list; the attempts option of /etc/resolv.conf is used by default",
+			EnvVars: []string{"DNS_LOOKUPER_ATTEMPTS"},
+		},
+		&cli.BoolFlag{
+			Name:    argNoSearch,
+			Usage:   "do not expand names with search domains of /etc/resolv.conf",
+			EnvVars: []string{"DNS_LOOKUPER_NO_SEARCH"},
+			Value:   false,
+		},
+		&cli.BoolFlag{
+			Name:    argNoCache,
+			Usage:   "query upstream servers every time instead of reusing responses while their TTL lasts",
+			EnvVars: []string{"DNS_LOOKUPER_NO_CACHE"},
+			Value:   false,
+		},
+		&cli.BoolFlag{
+			Name:    argIterative,
+			Usage:   "resolve names iteratively from the root servers instead of asking recursive resolvers",
+			EnvVars: []string{"DNS_LOOKUPER_ITERATIVE"},
+			Value:   false,
+		},
+		&cli.StringSliceFlag{
+			Name:    argRcode,
+			Usage:   fmt.Sprintf("policy for names answered with an rcode other than NOERROR in RCODE=policy form like SERVFAIL=retry; accepted policies are: %s; rcodes are warned about by default", rcodePolicyEnum),
+			EnvVars: []string{"DNS_LOOKUPER_RCODES"},
+		},
+		&cli.StringFlag{
+			Name:    argBackend,
+			Usage:   fmt.Sprintf("resolver backend; '%s' asks the resolver of the OS honoring /etc/hosts and nsswitch, '%s' queries DNS servers directly; accepted values are: %s", resolver.BackendSystem, resolver.BackendDNS, backendEnum),
+			EnvVars: []string{"DNS_LOOKUPER_BACKEND"},
+			Value:   backendDefault,
+		},
+		&cli.BoolFlag{
+			Name:    argStream,
+			Usage:   fmt.Sprintf("print names as soon as they are resolved instead of all at once, keeping memory use flat on huge lists; accepted formats are: %s", streamFormatEnum),
+			EnvVars: []string{"DNS_LOOKUPER_STREAM"},
+			Value:   false,
+		},
+		&cli.StringFlag{
+			Name:    argWildcard,
+			Usage:   fmt.Sprintf("detect names answered by wildcard records of their parent zones with the given policy; accepted policies are: %s; detection is disabled by default", wildcardPolicyEnum),
+			EnvVars: []string{"DNS_LOOKUPER_WILDCARD"},
+		},
+		&cli.StringSliceFlag{
+			Name:    argSubnet,
+			Usage:   fmt.Sprintf("client subnet to resolve names for with EDNS Client Subnet, as addr/bits or an address truncated to /%d or /%d; names are resolved once per subnet", v2.SubnetBitsIPv4Default, v2.SubnetBitsIPv6Default),
+			EnvVars: []string{"DNS_LOOKUPER_SUBNETS"},
+		},
+		&cli.StringFlag{
+			Name:    argSubnetMode,
+			Usage:   fmt.Sprintf("output for several client subnets; '%s' prints answers per subnet, '%s' merges them per name; accepted values are: %s", subnetModeBreakdown, subnetModeUnion, subnetModeEnum),
+			EnvVars: []string{"DNS_LOOKUPER_SUBNET_MODE"},
+			Value:   subnetModeDefault,
+		},
+		&cli.IntFlag{
+			Name:    argSamples,
+			Usage:   "number of times every name is queried to collect all of the addresses load balancers hand out in turns; answers are merged",
+			EnvVars: []string{"DNS_LOOKUPER_SAMPLES"},
+			Value:   1,
+		},
+		&cli.DurationFlag{
+			Name:    argSampleInterval,
+			Usage:   "pause between samples of a name in duration format like 100ms, 1s etc",
+			EnvVars: []string{"DNS_LOOKUPER_SAMPLE_INTERVAL"},
+		},
+		&cli.BoolFlag{
+			Name:    argSampleServers,
+			Usage:   "query each of the upstream servers with every sample instead of the first one answering",
+			EnvVars: []string{"DNS_LOOKUPER_SAMPLE_SERVERS"},
+			Value:   false,
+		},
+		&cli.StringFlag{
+			Name:    argDNSSEC,
+			Usage:   fmt.Sprintf("DNSSEC handling; '%s' reports the AD flag of upstream servers, '%s' validates answers up to a trust anchor and fails bogus ones; accepted values are: %s", v2.DNSSECReport, v2.DNSSECRequire, dnssecEnum),
+			EnvVars: []string{"DNS_LOOKUPER_DNSSEC"},
+			Value:   dnssecDefault,
+		},
+		&cli.StringSliceFlag{
+			Name:    argTrustAnchor,
+			Usage:   "DS or DNSKEY record in zone file format DNSSEC validation starts from; DS records of the root zone are used by default",
+			EnvVars: []string{"DNS_LOOKUPER_TRUST_ANCHORS"},
+		},
+		&cli.Float64Flag{
+			Name:    argRateLimit,
+			Usage:   "max number of queries per second to a single upstream server; queries over the limit wait for their turn; 0 means no limit",
+			EnvVars: []string{"DNS_LOOKUPER_RATE_LIMIT"},
+			Value:   v2.RateLimitDefault,
+		},
+	}
+
+	backendEnum = []string{
+		resolver.BackendSystem,
+		resolver.BackendDNS,
+	}
+
+	systemModeEnum = []string{
+		v1.ModeIpv4,
+		v1.ModeIpv6,
+		v1.ModeAll,
 	}
 
 	formatEnum = []string{
 		printer.FormatJSON,
+		printer.FormatJSONL,
 		printer.FormatYAML,
 		printer.FormatCSV,
 		printer.FormatHosts,
 		printer.FormatList,
 		printer.FormatTemplate,
+		printer.FormatDiff,
+	}
+
+	streamFormatEnum = []string{
+		printer.FormatJSONL,
+		printer.FormatCSV,
+		printer.FormatHosts,
+		printer.FormatList,
+		printer.FormatTemplate,
+	}
+
+	compareFormatEnum = []string{
+		printer.FormatDiff,
+		printer.FormatJSON,
+		printer.FormatYAML,
 	}
 
 	modeEnum = []string{
-		resolver.ModeIpv4,
-		resolver.ModeIpv6,
+		v2.ModeIpv4,
+		v2.ModeIpv6,
+		v2.ModeAll,
+		v2.ModePTR,
+	}
+
+	subnetModeEnum = []string{
+		subnetModeBreakdown,
+		subnetModeUnion,
+	}
+
+	dnssecEnum = []string{
+		v2.DNSSECOff,
+		v2.DNSSECReport,
+		v2.DNSSECRequire,
+	}
+
+	transportEnum = []string{
+		v2.TransportUDP,
+		v2.TransportTCP,
+	}
+
+	dohMethodEnum = []string{
+		http.MethodPost,
+		http.MethodGet,
 	}
 
 	argsConfigFile = []string{
@@ -160,16 +429,37 @@ var (
 	}
 
 	argCmdLine = []string{
+		argAttempts,
+		argBackend,
+		argConcurrency,
 		argDaemon,
+		argDNSSEC,
 		argFile,
 		argFormat,
 		argInterval,
+		argIterative,
+		argMaxInflight,
 		argMode,
+		argNoCache,
+		argNoSearch,
 		argOutput,
+		argRateLimit,
+		argRcode,
+		argSampleInterval,
+		argSampleServers,
+		argSamples,
+		argServer,
+		argStream,
+		argSubnet,
+		argSubnetMode,
+		argTaskTimeout,
 		argTemplateText,
 		argTemplateFooter,
 		argTemplateHeader,
 		argTimeout,
+		argTransport,
+		argTrustAnchor,
+		argWildcard,
 		argFile,
 	}
 )
@@ -179,9 +469,20 @@ func newConfig(clictx *cli.Context) (*config, error) {
 	result := &config{
 		Tasks: make([]task, 0),
 		Settings: &settings{
-			LookupTimeout: clictx.Duration(argTimeout).String(),
 			outputConsole: false,
 			Fail:          clictx.Bool(argFail),
+			Concurrency:   clictx.Int(argConcurrency),
+			MaxInflight:   clictx.Int(argMaxInflight),
+			Servers:       clictx.StringSlice(argServer),
+			Transport:     clictx.String(argTransport),
+			Attempts:      clictx.Int(argAttempts),
+			TrustAnchors:  clictx.StringSlice(argTrustAnchor),
+			Cache: &cacheSettings{
+				Enabled: boolPtr(!clictx.Bool(argNoCache)),
+			},
+			RateLimit: &rateLimitSettings{
+				QPS: clictx.Float64(argRateLimit),
+			},
 			DaemonSettings: &daemonSettings{
 				Enabled:  clictx.Bool(argDaemon),
 				Interval: clictx.String(argInterval),
@@ -189,6 +490,20 @@ func newConfig(clictx *cli.Context) (*config, error) {
 		},
 	}
 
+	if clictx.IsSet(argTimeout) {
+		result.Settings.LookupTimeout = clictx.Duration(argTimeout).String()
+	}
+
+	if clictx.IsSet(argTaskTimeout) {
+		result.Settings.TaskTimeout = clictx.Duration(argTaskTimeout).String()
+	}
+
+	rcodes, err := parseRcodePolicies(clictx.StringSlice(argRcode))
+	if err != nil {
+		return nil, err
+	}
+	result.Settings.Rcodes = rcodes
+
 	if configFileIsSet(clictx) && cmdLineIsSet(clictx) {
 		return nil, fmt.Errorf("it is allowed to install either a config file or command line parameters")
 	}
@@ -209,10 +524,21 @@ func newConfig(clictx *cli.Context) (*config, error) {
 
 	} else if cmdLineIsSet(clictx) {
 		singleton := task{
-			Files:  clictx.StringSlice(argFile),
-			Output: clictx.String(argOutput),
-			Mode:   clictx.String(argMode),
-			Format: clictx.String(argFormat),
+			Backend:        clictx.String(argBackend),
+			Files:          clictx.StringSlice(argFile),
+			Output:         clictx.String(argOutput),
+			Mode:           clictx.String(argMode),
+			Format:         clictx.String(argFormat),
+			Search:         boolPtr(!clictx.Bool(argNoSearch)),
+			Iterative:      clictx.Bool(argIterative),
+			Stream:         clictx.Bool(argStream),
+			Wildcard:       clictx.String(argWildcard),
+			Subnets:        clictx.StringSlice(argSubnet),
+			SubnetMode:     clictx.String(argSubnetMode),
+			Samples:        clictx.Int(argSamples),
+			SampleInterval: clictx.Duration(argSampleInterval).String(),
+			SampleServers:  clictx.Bool(argSampleServers),
+			DNSSEC:         clictx.String(argDNSSEC),
 			Template: &printer.Template{
 				Header: clictx.String(argTemplateHeader),
 				Text:   clictx.String(argTemplateText),
@@ -232,6 +558,16 @@ func newConfig(clictx *cli.Context) (*config, error) {
 		cli.ShowAppHelpAndExit(clictx, 42)
 	}
 
+	err = validateSettings(result.Settings)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Settings.cache, err = newCache(result.Settings.Cache)
+	if err != nil {
+		return nil, err
+	}
+
 	for index := range result.Tasks {
 		defaultValues(&result.Tasks[index])
 
@@ -261,7 +597,15 @@ func anyIsSet(clictx *cli.Context, args []string) bool {
 	return false
 }
 
+func boolPtr(b bool) *bool {
+	return &b
+}
+
 func defaultValues(t *task) {
+	if t.Backend == "" {
+		t.Backend = backendDefault
+	}
+
 	if t.Format == "" {
 		t.Format = formatDefault
 	}
@@ -269,6 +613,95 @@ func defaultValues(t *task) {
 	if t.Mode == "" {
 		t.Mode = modeDefault
 	}
+
+	if t.Search == nil {
+		t.Search = boolPtr(true)
+	}
+
+	if t.SubnetMode == "" {
+		t.SubnetMode = subnetModeDefault
+	}
+
+	if t.DNSSEC == "" {
+		t.DNSSEC = dnssecDefault
+	}
+}
+
+func validateSettings(s *settings) error {
+	if s.Concurrency < 1 {
+		return fmt.Errorf("concurrency must be a positive number, got %d", s.Concurrency)
+	}
+
+	if s.MaxInflight < 0 {
+		return fmt.Errorf("max inflight must not be negative, got %d", s.MaxInflight)
+	}
+
+	if s.Attempts < 0 {
+		return fmt.Errorf("attempts must not be negative, got %d", s.Attempts)
+	}
+
+	if s.LookupTimeout != "" {
+		if _, err := time.ParseDuration(s.LookupTimeout); err != nil {
+			return fmt.Errorf("error while parsing lookup timeout: %+v", err)
+		}
+	}
+
+	if s.TaskTimeout != "" {
+		if _, err := time.ParseDuration(s.TaskTimeout); err != nil {
+			return fmt.Errorf("error while parsing task timeout: %+v", err)
+		}
+	}
+
+	if s.Backoff != "" {
+		if _, err := time.ParseDuration(s.Backoff); err != nil {
+			return fmt.Errorf("error while parsing backoff: %+v", err)
+		}
+	}
+
+	if s.Transport != "" && !slices.Contains(transportEnum, s.Transport) {
+		return fmt.Errorf("unsupported transport %s; valid transports are %s", s.Transport, transportEnum)
+	}
+
+	err := validateDoH(s.DoH)
+	if err != nil {
+		return err
+	}
+
+	err = validateRateLimit(s.RateLimit)
+	if err != nil {
+		return err
+	}
+
+	err = validateRcodePolicies(s.Rcodes)
+	if err != nil {
+		return err
+	}
+
+	if s.MaxDepth < 0 {
+		return fmt.Errorf("max depth must not be negative, got %d", s.MaxDepth)
+	}
+
+	err = normalizeServers(s.RootHints)
+	if err != nil {
+		return err
+	}
+
+	for _, hint := range s.RootHints {
+		if strings.Contains(hint, "://") {
+			return fmt.Errorf("root hint %s must be a plain DNS server", hint)
+		}
+	}
+
+	s.trustAnchors = make([]dns.RR, 0, len(s.TrustAnchors))
+	for _, anchor := range s.TrustAnchors {
+		rr, err := v2.ParseTrustAnchor(anchor)
+		if err != nil {
+			return err
+		}
+		s.trustAnchors = append(s.trustAnchors, rr)
+	}
+
+	return normalizeServers(s.Servers)
 }
 
 func validateTask(t *task, s *settings) error {
@@ -287,14 +720,78 @@ func validateTask(t *task, s *settings) error {
 		s.outputConsole = true
 	}
 
-	if !slices.Contains(modeEnum, t.Mode) {
-		return fmt.Errorf("unsupported mode %s; valid modes are %s", t.Mode, modeEnum)
+	// Modes are matched in lower case, record types in any case.
+	t.Mode = strings.ToLower(t.Mode)
+
+	err := validateBackend(t)
+	if err != nil {
+		return err
+	}
+
+	err = normalizeServers(t.Servers)
+	if err != nil {
+		return err
+	}
+
+	if t.Transport != "" && !slices.Contains(transportEnum, t.Transport) {
+		return fmt.Errorf("unsupported transport %s; valid transports are %s", t.Transport, transportEnum)
+	}
+
+	err = validateDoH(t.DoH)
+	if err != nil {
+		return err
+	}
+
+	err = validateRateLimit(t.RateLimit)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := v2.ParseType(t.Mode); !ok && !slices.Contains(modeEnum, t.Mode) {
+		return fmt.Errorf("unsupported mode %s; valid modes are %s or a record type", t.Mode, modeEnum)
+	}
+
+	for _, recordType := range t.Types {
+		if _, ok := v2.ParseType(recordType); !ok {
+			return fmt.Errorf("unsupported record type %s", recordType)
+		}
 	}
 
 	if !slices.Contains(formatEnum, t.Format) {
 		return fmt.Errorf("unsupported output format %s; valid formats are %s", t.Format, formatEnum)
 	}
 
+	err = validateCompare(t)
+	if err != nil {
+		return err
+	}
+
+	err = validateSubnets(t)
+	if err != nil {
+		return err
+	}
+
+	err = validateSamples(t)
+	if err != nil {
+		return err
+	}
+
+	if t.Stream && (len(t.Compare) > 0 || len(t.Subnets) > 0 || !slices.Contains(streamFormatEnum, t.Format)) {
+		return fmt.Errorf("streaming is available only for output formats %s and not for comparisons or client subnets", streamFormatEnum)
+	}
+
+	if t.DNSSEC != "" && !slices.Contains(dnssecEnum, t.DNSSEC) {
+		return fmt.Errorf("unsupported dnssec mode %s; valid modes are %s", t.DNSSEC, dnssecEnum)
+	}
+
+	if t.Wildcard != "" && !slices.Contains(wildcardPolicyEnum, t.Wildcard) {
+		return fmt.Errorf("unsupported wildcard policy %s; valid policies are %s", t.Wildcard, wildcardPolicyEnum)
+	}
+
+	if t.Iterative && (len(t.Servers) > 0 || len(t.Compare) > 0) {
+		return fmt.Errorf("servers of the task are not used in iterative mode")
+	}
+
 	if t.Format == printer.FormatTemplate && t.Template.Text == "" {
 		return fmt.Errorf(`you must specify template text at least (--%[1]s or template key in file) when output format is "%[2]s"`, argTemplateText, printer.FormatTemplate)
 	}
@@ -309,3 +806,185 @@ func validateTask(t *task, s *settings) error {
 
 	return nil
 }
+
+// newCache returns the cache shared by all tasks for the lifetime of the
+// process; it is nil when caching is disabled.
+func newCache(c *cacheSettings) (*v2.Cache, error) {
+	if c == nil || (c.Enabled != nil && !*c.Enabled) {
+		return nil, nil
+	}
+
+	minTTL, err := parseDuration(c.MinTTL)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing cache min ttl: %+v", err)
+	}
+
+	maxTTL := v2.CacheMaxTTLDefault
+	if c.MaxTTL != "" {
+		maxTTL, err = time.ParseDuration(c.MaxTTL)
+		if err != nil {
+			return nil, fmt.Errorf("error while parsing cache max ttl: %+v", err)
+		}
+	}
+
+	if maxTTL > 0 && minTTL > maxTTL {
+		return nil, fmt.Errorf("cache min ttl %s is greater than max ttl %s", minTTL, maxTTL)
+	}
+
+	cache := v2.NewCache().
+		WithMinTTL(minTTL).
+		WithMaxTTL(maxTTL)
+
+	return cache, nil
+}
+
+// validateBackend rejects options the system backend has no control over;
+// settings of upstream servers are simply not used by its tasks.
+func validateBackend(t *task) error {
+	if !slices.Contains(backendEnum, t.Backend) {
+		return fmt.Errorf("unsupported backend %s; valid backends are %s", t.Backend, backendEnum)
+	}
+
+	if t.Backend != resolver.BackendSystem {
+		return nil
+	}
+
+	if !slices.Contains(systemModeEnum, t.Mode) || len(t.Types) > 0 {
+		return fmt.Errorf("backend %s supports only modes %s", t.Backend, systemModeEnum)
+	}
+
+	if len(t.Servers) > 0 || t.Transport != "" || t.TLS != nil || t.DoH != nil || t.RateLimit != nil || t.Iterative || len(t.Compare) > 0 || t.Wildcard != "" || len(t.Subnets) > 0 || t.Samples > 1 || t.SampleServers ||
+		(t.DNSSEC != "" && t.DNSSEC != v2.DNSSECOff) {
+		return fmt.Errorf("servers, transport, tls, doh, rate limit, iterative, compare, wildcard, subnets, samples and dnssec options of the task require backend %s", resolver.BackendDNS)
+	}
+
+	return nil
+}
+
+// validateSubnets brings client subnets to their canonical form, so answers
+// are labelled alike however subnets are written.
+func validateSubnets(t *task) error {
+	if t.SubnetMode != "" && !slices.Contains(subnetModeEnum, t.SubnetMode) {
+		return fmt.Errorf("unsupported subnet mode %s; valid modes are %s", t.SubnetMode, subnetModeEnum)
+	}
+
+	if len(t.Subnets) > 0 && len(t.Compare) > 0 {
+		return fmt.Errorf("client subnets are not available for comparisons")
+	}
+
+	for index := range t.Subnets {
+		subnet, err := v2.ParseSubnet(t.Subnets[index])
+		if err != nil {
+			return err
+		}
+		t.Subnets[index] = subnet.String()
+	}
+
+	return nil
+}
+
+func validateSamples(t *task) error {
+	if t.Samples < 0 {
+		return fmt.Errorf("samples must not be negative, got %d", t.Samples)
+	}
+
+	_, err := parseDuration(t.SampleInterval)
+	if err != nil {
+		return fmt.Errorf("error while parsing sample interval: %+v", err)
+	}
+
+	if t.SampleServers && t.Iterative {
+		return fmt.Errorf("samples of all servers are not available in iterative mode")
+	}
+
+	return nil
+}
+
+func validateDoH(d *dohSettings) error {
+	if d == nil || d.Method == "" {
+		return nil
+	}
+
+	d.Method = strings.ToUpper(d.Method)
+	if !slices.Contains(dohMethodEnum, d.Method) {
+		return fmt.Errorf("unsupported doh method %s; valid methods are %s", d.Method, dohMethodEnum)
+	}
+
+	return nil
+}
+
+func validateCompare(t *task) error {
+	if len(t.Compare) == 0 {
+		if t.Format == printer.FormatDiff {
+			return fmt.Errorf(`output format "%s" is available only for tasks with servers to compare`, printer.FormatDiff)
+		}
+		return nil
+	}
+
+	if len(t.Compare) < 2 {
+		return fmt.Errorf("at least two servers are required to compare, got %d", len(t.Compare))
+	}
+
+	err := normalizeServers(t.Compare)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(compareFormatEnum, t.Format) {
+		return fmt.Errorf("unsupported output format %s for comparison; valid formats are %s", t.Format, compareFormatEnum)
+	}
+
+	_, err = parseThreshold(t.CompareThreshold, 0)
+	return err
+}
+
+// parseThreshold returns the number of divergent names allowed out of total;
+// the threshold is either a number of names or a percentage like "5%".
+func parseThreshold(threshold string, total int) (int, error) {
+	if threshold == "" {
+		return 0, nil
+	}
+
+	if percent, ok := strings.CutSuffix(threshold, "%"); ok {
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil || p < 0 || p > 100 {
+			return 0, fmt.Errorf("invalid compare threshold %s", threshold)
+		}
+		return int(float64(total) * p / 100), nil
+	}
+
+	n, err := strconv.Atoi(threshold)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid compare threshold %s", threshold)
+	}
+
+	return n, nil
+}
+
+func validateRateLimit(l *rateLimitSettings) error {
+	if l == nil {
+		return nil
+	}
+
+	if l.QPS < 0 {
+		return fmt.Errorf("rate limit must not be negative, got %v", l.QPS)
+	}
+
+	if l.Burst < 0 {
+		return fmt.Errorf("rate limit burst must not be negative, got %d", l.Burst)
+	}
+
+	return nil
+}
+
+func normalizeServers(servers []string) error {
+	for index := range servers {
+		server, err := v2.ParseServer(servers[index])
+		if err != nil {
+			return err
+		}
+		servers[index] = server
+	}
+
+	return nil
+}
@@ -1,15 +1,25 @@
 package lookuper
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"os/signal"
 	"path"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/pabateman/dns-lookuper/internal/parser"
 	"github.com/pabateman/dns-lookuper/internal/printer"
-	"github.com/pabateman/dns-lookuper/internal/resolver/v2"
+	"github.com/pabateman/dns-lookuper/internal/resolver"
+	v1 "github.com/pabateman/dns-lookuper/internal/resolver/v1"
+	v2 "github.com/pabateman/dns-lookuper/internal/resolver/v2"
 
 	log "github.com/sirupsen/logrus"
 	cli "github.com/urfave/cli/v2"
@@ -28,14 +38,19 @@ func Lookup(clictx *cli.Context) error {
 		FullTimestamp: true,
 	})
 
+	ctx, stop := signal.NotifyContext(clictx.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	if config.Settings.DaemonSettings.Enabled {
-		return daemonMode(config)
+		return daemonMode(ctx, config)
 	} else {
-		return walkTasks(config)
+		return walkTasks(ctx, config)
 	}
 }
 
-func daemonMode(config *config) error {
+// daemonMode walks tasks on every tick until ctx is done; a walkthrough in
+// progress is aborted then and the daemon stops with no error.
+func daemonMode(ctx context.Context, config *config) error {
 	intervalDuration, err := time.ParseDuration(config.Settings.DaemonSettings.Interval)
 	if err != nil {
 		return fmt.Errorf("error while parsing lookup interval: %+v", err)
@@ -49,7 +64,11 @@ func daemonMode(config *config) error {
 	errorsChan := make(chan error, 1)
 
 	log.Info("perform the very first task walkthrough")
-	err = walkTasks(config)
+	err = walkTasks(ctx, config)
+	if ctx.Err() != nil {
+		log.Info("stopping lookup daemon")
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -58,19 +77,30 @@ func daemonMode(config *config) error {
 		select {
 		case <-ticker.C:
 			log.Infof("perform task walkthrough")
-			err := walkTasks(config)
-			if err != nil {
+			err := walkTasks(ctx, config)
+			if err != nil && ctx.Err() == nil {
 				errorsChan <- err
 			}
 		case err := <-errorsChan:
 			return err
+		case <-ctx.Done():
+			log.Info("stopping lookup daemon")
+			return nil
 		}
 	}
 }
 
-func walkTasks(config *config) error {
+func walkTasks(ctx context.Context, config *config) error {
+	if config.Settings.cache != nil {
+		config.Settings.cache.Prune()
+	}
+
 	for _, task := range config.Tasks {
-		err := performTask(&task, config.Settings)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := performTask(ctx, &task, config.Settings)
 		if err != nil {
 			return err
 		}
@@ -80,82 +110,413 @@ func walkTasks(config *config) error {
 
 }
 
-func performTask(t *task, s *settings) error {
-	pathsList := t.Files
-	domainNames := parser.NewDomainNames()
+// performTask resolves names of the task and prints them once all of them
+// are resolved, so a task cancelled or out of time leaves its output intact.
+func performTask(ctx context.Context, t *task, s *settings) error {
+	taskTimeout, err := parseDuration(s.TaskTimeout)
+	if err != nil {
+		return fmt.Errorf("error while parsing task timeout: %+v", err)
+	}
+
+	if taskTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, taskTimeout)
+		defer cancel()
+	}
+
+	domainNames := parser.NewDomainNames().WithReverse(reverseTask(t))
 
-	for _, p := range pathsList {
-		err := domainNames.ParseFile(getPath(s, p))
+	paths := make([]string, 0, len(t.Files))
+	for _, p := range t.Files {
+		paths = append(paths, getPath(s, p))
+	}
+
+	if t.Stream {
+		r, err := newTaskResolver(t, s)
 		if err != nil {
-			return fmt.Errorf("error while parsing domain names list from %s: %+v", p, err)
+			return err
 		}
+
+		return streamTask(ctx, t, s, r, domainNames, paths)
 	}
 
-	if len(domainNames.UnparsedNames) > 0 {
-		if s.Fail {
-			for file := range domainNames.UnparsedNames {
-				log.Errorf("%s from %s is not valid DNS name", strings.Join(domainNames.UnparsedNames[file], " "), file)
-			}
-			return fmt.Errorf("error while parsing domain names")
-		} else {
-			for file := range domainNames.UnparsedNames {
-				log.Warnf("%s from %s is not valid DNS name, skipping", strings.Join(domainNames.UnparsedNames[file], " "), file)
-			}
+	for i, p := range paths {
+		err = domainNames.ParseFile(p)
+		if err != nil {
+			return fmt.Errorf("error while parsing domain names list from %s: %+v", t.Files[i], err)
 		}
 	}
 
-	lookupTimeout, err := time.ParseDuration(s.LookupTimeout)
+	err = reportUnparsed(domainNames, s)
 	if err != nil {
-		return fmt.Errorf("error while parsing lookup timeout: %+v", err)
+		return err
 	}
 
-	r := resolver.NewResolver().
-		WithMode(t.Mode).
-		WithTimeout(lookupTimeout)
+	if len(t.Compare) > 0 {
+		return compareTask(ctx, t, s, domainNames.ParsedNames)
+	}
+
+	if len(t.Subnets) > 0 {
+		return subnetTask(ctx, t, s, domainNames.ParsedNames)
+	}
+
+	r, err := newTaskResolver(t, s)
+	if err != nil {
+		return err
+	}
+
+	responses, err := resolveTask(ctx, r, s, domainNames.ParsedNames)
+	if err != nil {
+		return err
+	}
+
+	err = reportResponses(t, s, responses)
+	if err != nil {
+		return err
+	}
+
+	responses = resolver.FilterResponsesNoerror(responses)
+
+	p := printer.NewPrinter().WithEntries(responses)
+
+	return printTask(t, s, p)
+}
+
+// reverseTask tells whether names of the task are addresses to look up PTR
+// records of, either by the mode or by the record types.
+func reverseTask(t *task) bool {
+	if t.Mode == v2.ModePTR {
+		return true
+	}
+
+	return slices.ContainsFunc(t.Types, func(recordType string) bool {
+		qtype, _ := v2.ParseType(recordType)
+		return qtype == dns.TypePTR
+	})
+}
+
+// reportUnparsed logs names of the lists that are not valid, which fails the
+// task with --fail.
+func reportUnparsed(d *parser.DomainNames, s *settings) error {
+	if len(d.UnparsedNames) == 0 {
+		return nil
+	}
+
+	entity := "DNS name"
+	if d.Reverse() {
+		entity = "address or network of up to 256 addresses"
+	}
+
+	if s.Fail {
+		for file := range d.UnparsedNames {
+			log.Errorf("%s from %s is not valid %s", strings.Join(d.UnparsedNames[file], " "), file, entity)
+		}
+		return fmt.Errorf("error while parsing domain names")
+	}
+
+	for file := range d.UnparsedNames {
+		log.Warnf("%s from %s is not valid %s, skipping", strings.Join(d.UnparsedNames[file], " "), file, entity)
+	}
+
+	return nil
+}
+
+// resolveTask resolves names of a task and retries rcodes of the retry policy.
+func resolveTask(ctx context.Context, r resolver.Resolver, s *settings, names []string) ([]resolver.Response, error) {
+	responses, err := r.Resolve(ctx, names)
+	if err == nil {
+		responses, err = retryRcodes(ctx, r, responses, s)
+	}
+	logStats(r)
+	if ctx.Err() != nil {
+		return nil, taskAborted(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while resolving domain name: %+v", err)
+	}
+
+	return responses, nil
+}
 
-	responses, err := r.Resolve(domainNames.ParsedNames)
+// reportResponses logs rcodes, wildcards and partial results of responses
+// and fails when any of them is fatal.
+func reportResponses(t *task, s *settings, responses []resolver.Response) error {
+	err := reportRcodes(responses, s)
 	if err != nil {
-		return fmt.Errorf("error while resolving domain name: %+v", err)
+		return err
+	}
+
+	err = reportWildcards(responses, t, s)
+	if err != nil {
+		return err
+	}
+
+	responsesPartial := resolver.FilterResponsesPartial(responses)
+
+	for _, response := range responsesPartial {
+		logPartial(response, s)
+	}
+
+	if len(responsesPartial) > 0 && s.Fail {
+		return fmt.Errorf("encountered errors while resolving domain names")
+	}
+
+	return nil
+}
+
+// subnetTask resolves names once per client subnet of the task and prints
+// answers of every subnet labelled with it, or merged per name in union mode.
+func subnetTask(ctx context.Context, t *task, s *settings, names []string) error {
+	responses := make([]resolver.Response, 0, len(names)*len(t.Subnets))
+
+	for _, subnet := range t.Subnets {
+		prefix, err := v2.ParseSubnet(subnet)
+		if err != nil {
+			return err
+		}
+
+		r, err := newDNSResolver(t, s)
+		if err != nil {
+			return err
+		}
+
+		r.WithServers(taskServers(t, s)).WithClientSubnet(prefix)
+
+		response, err := resolveTask(ctx, r, s, names)
+		if err != nil {
+			return err
+		}
+
+		responses = append(responses, response...)
+	}
+
+	err := reportResponses(t, s, responses)
+	if err != nil {
+		return err
 	}
 
-	responsesNxdomain := resolver.FilterResponsesNxdomain(responses)
+	responses = resolver.FilterResponsesNoerror(responses)
 
-	if len(responsesNxdomain) > 0 {
-		if s.Fail {
-			for _, response := range responsesNxdomain {
-				log.Errorf("%s: no such host", response.Name)
+	if t.SubnetMode == subnetModeUnion {
+		responses = resolver.Union(responses)
+	}
+
+	p := printer.NewPrinter().WithEntries(responses)
+
+	return printTask(t, s, p)
+}
+
+// streamTask prints responses as soon as they are resolved, so memory does not
+// grow with the number of names. The output is replaced only when all of the
+// names are printed; rcodes and partial results fail the task after that.
+func streamTask(ctx context.Context, t *task, s *settings, r resolver.Resolver, domainNames *parser.DomainNames, paths []string) error {
+	report := newRcodeReport(s)
+	wildcards := newWildcardReport(t, s)
+	partial := false
+	var resolveErr, listErr error
+
+	responses := func(yield func(resolver.Response, error) bool) {
+		for response, err := range r.ResolveStream(ctx, domainNames.Stream(paths)) {
+			if err == nil && response.Rcode != dns.RcodeToString[dns.RcodeSuccess] && rcodePolicy(s, response.Rcode) == rcodePolicyRetry {
+				log.Infof("%s: retrying after %s", response.Name, response.Rcode)
+
+				var retried []resolver.Response
+				retried, err = r.Resolve(v2.BypassCache(ctx), []string{response.Name})
+				if err == nil {
+					response = retried[0]
+				}
 			}
-			return fmt.Errorf("encountered errors while resolving domain names")
-		} else {
-			for _, response := range responsesNxdomain {
-				log.Warnf("%s: no such host", response.Name)
+
+			if err != nil {
+				resolveErr = err
+				yield(response, err)
+				return
+			}
+
+			report.add(response)
+			wildcards.add(response)
+
+			if response.Partial {
+				logPartial(response, s)
+				partial = true
+			}
+
+			if response.Rcode != dns.RcodeToString[dns.RcodeSuccess] {
+				continue
+			}
+
+			if !yield(response, nil) {
+				return
 			}
 		}
+
+		// Lists are read along with resolution, so their errors and invalid
+		// names fatal with --fail only show up at the end of the stream and
+		// keep the output from being replaced.
+		err := domainNames.Err()
+		if err != nil {
+			err = fmt.Errorf("error while parsing domain names list: %+v", err)
+		} else {
+			err = reportUnparsed(domainNames, s)
+		}
+
+		if err != nil && ctx.Err() == nil {
+			listErr = err
+			yield(resolver.Response{}, err)
+		}
 	}
 
-	responses = resolver.FilterResponsesNoerror(responses)
+	err := printTask(t, s, printer.NewPrinter().WithStream(responses))
+	logStats(r)
+	if ctx.Err() != nil {
+		return taskAborted(ctx)
+	}
+	if resolveErr != nil {
+		return fmt.Errorf("error while resolving domain name: %+v", resolveErr)
+	}
+	if listErr != nil {
+		return listErr
+	}
+	if err != nil {
+		return err
+	}
 
-	var outputFile *os.File
+	err = report.err()
+	if err != nil {
+		return err
+	}
 
-	if t.Output == "-" || t.Output == "/dev/stdout" {
-		outputFile = os.Stdout
+	err = wildcards.err()
+	if err != nil {
+		return err
+	}
+
+	if partial && s.Fail {
+		return fmt.Errorf("encountered errors while resolving domain names")
+	}
+
+	return nil
+}
+
+// logPartial logs a name some of the queries for which failed; it is an error
+// with --fail.
+func logPartial(response resolver.Response, s *settings) {
+	if s.Fail {
+		log.Errorf("%s: partial result: %s", response.Name, strings.Join(response.Errors, "; "))
 	} else {
-		outputFile, err = os.Create(getPath(s, t.Output))
+		log.Warnf("%s: partial result: %s", response.Name, strings.Join(response.Errors, "; "))
+	}
+}
+
+// compareTask resolves names with every server of the compare list and prints
+// the differences; it fails when more names diverge than the threshold allows.
+func compareTask(ctx context.Context, t *task, s *settings, names []string) error {
+	responses := make([][]resolver.Response, 0, len(t.Compare))
+
+	for _, server := range t.Compare {
+		r, err := newDNSResolver(t, s)
+		if err != nil {
+			return err
+		}
+
+		response, err := r.WithServers([]string{server}).Resolve(ctx, names)
+		logStats(r)
+		if ctx.Err() != nil {
+			return taskAborted(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("error while resolving domain name with %s: %+v", server, err)
+		}
+
+		responses = append(responses, response)
+	}
+
+	comparisons := resolver.Compare(t.Compare, responses)
+	divergent := resolver.FilterComparisonsDivergent(comparisons)
+
+	for _, comparison := range divergent {
+		log.Warnf("%s: answers of %s diverge", comparison.Name, strings.Join(t.Compare, ", "))
+	}
+
+	p := printer.NewPrinter().WithComparisons(comparisons)
+
+	err := printTask(t, s, p)
+	if err != nil {
+		return err
+	}
+
+	threshold, err := parseThreshold(t.CompareThreshold, len(comparisons))
+	if err != nil {
+		return err
+	}
+
+	if len(divergent) > threshold {
+		return fmt.Errorf("%d of %d names diverge between %s, the threshold is %d", len(divergent), len(comparisons), strings.Join(t.Compare, ", "), threshold)
+	}
+
+	return nil
+}
+
+// taskAborted tells a task out of its timeout from one cancelled by a signal.
+func taskAborted(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("task did not complete within task timeout: %w", ctx.Err())
+	}
+
+	return fmt.Errorf("task aborted: %w", ctx.Err())
+}
+
+func printTask(t *task, s *settings, p *printer.Printer) error {
+	p.WithTemplate(t.Template).
+		WithFormat(t.Format)
+
+	if t.Output == "-" || t.Output == "/dev/stdout" {
+		return printOutput(p, os.Stdout)
+	}
+
+	output := getPath(s, t.Output)
+	if info, err := os.Stat(output); err == nil && !info.Mode().IsRegular() {
+		outputFile, err := os.Create(output)
 		if err != nil {
 			return err
 		}
 
 		// nolint:errcheck
 		defer outputFile.Close()
+
+		return printOutput(p, outputFile)
 	}
 
-	printer := printer.NewPrinter().
-		WithEntries(responses).
-		WithTemplate(t.Template).
-		WithFormat(t.Format).
-		WithOutput(outputFile)
+	// The result is written next to the output and moved over it once
+	// complete, so a failed or cancelled task never leaves a truncated file.
+	outputFile, err := os.CreateTemp(path.Dir(output), "."+path.Base(output)+".*")
+	if err != nil {
+		return err
+	}
 
-	err = printer.Print()
+	// nolint:errcheck
+	defer os.Remove(outputFile.Name())
+	// nolint:errcheck
+	defer outputFile.Close()
+
+	err = printOutput(p, outputFile)
+	if err != nil {
+		return err
+	}
+
+	err = outputFile.Chmod(0o644)
+	if err == nil {
+		err = outputFile.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("error while writing result:%+v", err)
+	}
+
+	return os.Rename(outputFile.Name(), output)
+}
+
+func printOutput(p *printer.Printer, output *os.File) error {
+	err := p.WithOutput(output).Print()
 	if err != nil {
 		return fmt.Errorf("error while writing result:%+v", err)
 	}
@@ -163,6 +524,173 @@ func performTask(t *task, s *settings) error {
 	return nil
 }
 
+// newTaskResolver builds the resolver of the backend the task is set to.
+func newTaskResolver(t *task, s *settings) (resolver.Resolver, error) {
+	if t.Backend == resolver.BackendSystem {
+		lookupTimeout, err := parseDuration(s.LookupTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("error while parsing lookup timeout: %+v", err)
+		}
+
+		r := v1.NewResolver().WithMode(t.Mode)
+		if lookupTimeout > 0 {
+			r.WithTimeout(lookupTimeout)
+		}
+
+		return r, nil
+	}
+
+	r, err := newDNSResolver(t, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.WithServers(taskServers(t, s)), nil
+}
+
+// newDNSResolver builds the resolver of the dns backend with options of the
+// task and settings; servers are left to the caller.
+func newDNSResolver(t *task, s *settings) (*v2.Resolver, error) {
+	lookupTimeout, err := parseDuration(s.LookupTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing lookup timeout: %+v", err)
+	}
+
+	backoff, err := parseDuration(s.Backoff)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing backoff: %+v", err)
+	}
+
+	tlsConfig, err := taskTLSConfig(t, s)
+	if err != nil {
+		return nil, fmt.Errorf("error while loading tls settings: %+v", err)
+	}
+
+	sampleInterval, err := parseDuration(t.SampleInterval)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing sample interval: %+v", err)
+	}
+
+	dohSettings := s.DoH
+	if t.DoH != nil {
+		dohSettings = t.DoH
+	}
+
+	r := v2.NewResolver().
+		WithMode(t.Mode).
+		WithTypes(t.Types).
+		WithTimeout(lookupTimeout).
+		WithConcurrency(s.Concurrency).
+		WithMaxInflight(s.MaxInflight).
+		WithAttempts(s.Attempts).
+		WithRotate(s.Rotate).
+		WithBackoff(backoff).
+		WithTransport(taskTransport(t, s)).
+		WithTLSConfig(tlsConfig).
+		WithSearch(t.Search == nil || *t.Search).
+		WithIterative(t.Iterative).
+		WithWildcard(t.Wildcard != "").
+		WithSamples(t.Samples).
+		WithSampleInterval(sampleInterval).
+		WithSampleServers(t.SampleServers).
+		WithDNSSEC(t.DNSSEC).
+		WithTrustAnchors(s.trustAnchors).
+		WithRootHints(s.RootHints)
+
+	if s.MaxDepth > 0 {
+		r.WithMaxDepth(s.MaxDepth)
+	}
+
+	if t.Cache == nil || *t.Cache {
+		r.WithCache(s.cache)
+	}
+
+	if limit := taskRateLimit(t, s); limit != nil {
+		r.WithRateLimit(limit.QPS, limit.Burst)
+	}
+
+	if dohSettings != nil {
+		if dohSettings.Method != "" {
+			r.WithDoHMethod(dohSettings.Method)
+		}
+		r.WithDoHHeaders(dohSettings.Headers)
+	}
+
+	return r, nil
+}
+
+func taskServers(t *task, s *settings) []string {
+	if len(t.Servers) > 0 {
+		return t.Servers
+	}
+
+	return s.Servers
+}
+
+func taskTransport(t *task, s *settings) string {
+	if t.Transport != "" {
+		return t.Transport
+	}
+
+	return s.Transport
+}
+
+func taskRateLimit(t *task, s *settings) *rateLimitSettings {
+	if t.RateLimit != nil {
+		return t.RateLimit
+	}
+
+	return s.RateLimit
+}
+
+func taskTLSConfig(t *task, s *settings) (*tls.Config, error) {
+	tlsSettings := s.TLS
+	if t.TLS != nil {
+		tlsSettings = t.TLS
+	}
+
+	if tlsSettings == nil {
+		return nil, nil
+	}
+
+	caFile := tlsSettings.CAFile
+	if caFile != "" {
+		caFile = getPath(s, caFile)
+	}
+
+	return v2.NewTLSConfig(tlsSettings.ServerName, caFile, tlsSettings.SPKIPins)
+}
+
+// logStats sums up queries sent to every upstream server, including the time
+// queries waited for the rate limit; the system backend keeps no stats.
+func logStats(r resolver.Resolver) {
+	dnsResolver, ok := r.(*v2.Resolver)
+	if !ok {
+		return
+	}
+
+	stats := dnsResolver.Stats()
+	servers := slices.Sorted(maps.Keys(stats))
+
+	for _, server := range servers {
+		s := stats[server]
+		if s.Throttled > 0 {
+			log.Infof("%s: %d queries, %d failed, throttled for %s", server, s.Queries, s.Failures, s.Throttled.Round(time.Millisecond))
+		} else {
+			log.Infof("%s: %d queries, %d failed", server, s.Queries, s.Failures)
+		}
+	}
+}
+
+// parseDuration treats an empty string as an unset duration.
+func parseDuration(d string) (time.Duration, error) {
+	if d == "" {
+		return 0, nil
+	}
+
+	return time.ParseDuration(d)
+}
+
 func getPath(settings *settings, p string) string {
 	if path.IsAbs(p) {
 		return p
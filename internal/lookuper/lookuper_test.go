@@ -1,11 +1,17 @@
 package lookuper
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"os"
 	"path"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/miekg/dns"
+	"github.com/pabateman/dns-lookuper/internal/printer"
 	"github.com/stretchr/testify/require"
 )
 
@@ -45,7 +51,7 @@ func TestTaskBasic(t *testing.T) {
 		Output: output,
 	}
 
-	err := performTask(task, settings)
+	err := performTask(context.Background(), task, settings)
 	require.Nil(t, err)
 
 	expected, err := getFilesAsString(path.Join(expectedContentDirectory, "basic-list.txt"))
@@ -95,7 +101,7 @@ func TestTaskMultiple(t *testing.T) {
 		},
 	}
 
-	err := walkTasks(config)
+	err := walkTasks(context.Background(), config)
 	require.Nil(t, err)
 
 	actual, err := getFilesAsString(outputs...)
@@ -107,7 +113,7 @@ func TestTaskMultiple(t *testing.T) {
 	require.Equal(t, expected, actual)
 
 	config.Settings.Fail = true
-	err = walkTasks(config)
+	err = walkTasks(context.Background(), config)
 	require.NotNil(t, err)
 
 	for _, output := range outputs {
@@ -134,7 +140,7 @@ func TestTaskFails(t *testing.T) {
 		},
 	}
 
-	err := walkTasks(config)
+	err := walkTasks(context.Background(), config)
 	require.Nil(t, err)
 
 	expected, err := getFilesAsString(path.Join(expectedContentDirectory, "multiple-02.txt"))
@@ -146,19 +152,19 @@ func TestTaskFails(t *testing.T) {
 	require.Equal(t, expected, actual)
 
 	config.Settings.Fail = true
-	err = walkTasks(config)
+	err = walkTasks(context.Background(), config)
 	require.NotNil(t, err)
 
 	config.Settings.Fail = false
-	err = walkTasks(config)
+	err = walkTasks(context.Background(), config)
 	require.Nil(t, err)
 
 	config.Settings.LookupTimeout = "1"
-	err = walkTasks(config)
+	err = walkTasks(context.Background(), config)
 	require.NotNil(t, err)
 
 	config.Settings.LookupTimeout = "15s"
-	err = walkTasks(config)
+	err = walkTasks(context.Background(), config)
 	require.Nil(t, err)
 
 	inputNxdomain := path.Join(listsDirectory, "inputnxdomain.lst")
@@ -168,7 +174,7 @@ func TestTaskFails(t *testing.T) {
 	config.Tasks[0].Files = []string{inputNxdomain}
 	config.Tasks[0].Output = outputNxdomainPath
 
-	err = walkTasks(config)
+	err = walkTasks(context.Background(), config)
 	require.Nil(t, err)
 
 	actualNxdomain, err := getFilesAsString(outputNxdomainPath)
@@ -180,6 +186,553 @@ func TestTaskFails(t *testing.T) {
 	require.Equal(t, expectedNxdomain, actualNxdomain)
 
 	config.Settings.Fail = true
-	err = walkTasks(config)
+	err = walkTasks(context.Background(), config)
 	require.NotNil(t, err)
 }
+
+// startServer serves handler over UDP and TCP on a random loopback port and
+// returns the address of the server.
+func startServer(t *testing.T, handler dns.Handler) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	listener, err := net.Listen("tcp", pc.LocalAddr().String())
+	require.Nil(t, err)
+
+	serve(t, &dns.Server{PacketConn: pc, Handler: handler})
+	serve(t, &dns.Server{Listener: listener, Handler: handler})
+
+	return pc.LocalAddr().String()
+}
+
+func serve(t *testing.T, server *dns.Server) {
+	t.Helper()
+
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	<-started
+
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+}
+
+// newA returns the A record of name with the address.
+func newA(name string, address string) dns.RR {
+	return &dns.A{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP(address),
+	}
+}
+
+// recordsHandler answers names of the map with A records of their addresses
+// and with NXDOMAIN the rest.
+func recordsHandler(records map[string][]string) dns.HandlerFunc {
+	answers := make(map[string][]dns.RR)
+	for name, addresses := range records {
+		for _, address := range addresses {
+			answers[name] = append(answers[name], newA(name, address))
+		}
+	}
+
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(req)
+
+		answer, ok := answers[req.Question[0].Name]
+		if !ok {
+			msg.Rcode = dns.RcodeNameError
+		}
+		msg.Answer = answer
+
+		_ = w.WriteMsg(msg)
+	}
+}
+
+func TestTaskCompare(t *testing.T) {
+	internal := startServer(t, recordsHandler(map[string][]string{
+		"www.example.":      {"10.0.0.1"},
+		"api.example.":      {"10.0.0.2", "10.0.0.3"},
+		"intranet.example.": {"10.0.0.4"},
+	}))
+	external := startServer(t, recordsHandler(map[string][]string{
+		"www.example.": {"10.0.0.1"},
+		"api.example.": {"10.0.0.2", "192.0.2.3"},
+	}))
+
+	dir := t.TempDir()
+	err := os.WriteFile(path.Join(dir, "compare.lst"), []byte("www.example\napi.example\nintranet.example\n"), 0o644)
+	require.Nil(t, err)
+
+	task := &task{
+		Files:   []string{"compare.lst"},
+		Output:  "compare.txt",
+		Mode:    "ipv4",
+		Format:  "diff",
+		Search:  boolPtr(false),
+		Compare: []string{internal, external},
+	}
+
+	settings := &settings{
+		dir:           dir,
+		LookupTimeout: "1s",
+		Concurrency:   1,
+	}
+
+	err = performTask(context.Background(), task, settings)
+	require.NotNil(t, err)
+
+	actual, err := getFilesAsString(path.Join(dir, "compare.txt"))
+	require.Nil(t, err)
+
+	require.Equal(t, []string{fmt.Sprintf(`! api.example 10.0.0.2
+    %[1]s NOERROR +10.0.0.3 -192.0.2.3
+    %[2]s NOERROR +192.0.2.3 -10.0.0.3
+! intranet.example
+    %[1]s NOERROR +10.0.0.4
+    %[2]s NXDOMAIN -10.0.0.4
+= www.example 10.0.0.1
+`, internal, external)}, actual)
+
+	task.CompareThreshold = "2"
+	err = performTask(context.Background(), task, settings)
+	require.Nil(t, err)
+
+	task.CompareThreshold = "50%"
+	err = performTask(context.Background(), task, settings)
+	require.NotNil(t, err)
+
+	task.CompareThreshold = "100%"
+	err = performTask(context.Background(), task, settings)
+	require.Nil(t, err)
+
+	_, err = parseThreshold("-1", 10)
+	require.NotNil(t, err)
+
+	_, err = parseThreshold("101%", 10)
+	require.NotNil(t, err)
+}
+
+func TestTaskBackend(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(path.Join(dir, "hosts.lst"), []byte("localhost\n"), 0o644)
+	require.Nil(t, err)
+
+	task := &task{
+		Backend: "system",
+		Files:   []string{"hosts.lst"},
+		Output:  "hosts.txt",
+		Mode:    "ipv4",
+		Format:  "hosts",
+	}
+
+	settings := &settings{
+		dir:            dir,
+		DaemonSettings: &daemonSettings{},
+	}
+
+	err = validateTask(task, settings)
+	require.Nil(t, err)
+
+	err = performTask(context.Background(), task, settings)
+	require.Nil(t, err)
+
+	actual, err := getFilesAsString(path.Join(dir, "hosts.txt"))
+	require.Nil(t, err)
+	require.Equal(t, []string{"127.0.0.1 localhost\n"}, actual)
+
+	task.Servers = []string{"127.0.0.1"}
+	require.NotNil(t, validateTask(task, settings))
+
+	task.Servers = nil
+	task.DNSSEC = "report"
+	require.NotNil(t, validateTask(task, settings))
+
+	task.DNSSEC = "off"
+	task.Mode = "mx"
+	require.NotNil(t, validateTask(task, settings))
+
+	task.Mode = "ipv4"
+	task.Backend = "libc"
+	require.NotNil(t, validateTask(task, settings))
+}
+
+func TestTaskReverse(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(path.Join(dir, "addresses.lst"), []byte("192.0.2.1\n"), 0o644)
+	require.Nil(t, err)
+
+	server := startServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(req)
+
+		question := req.Question[0]
+		if question.Name == "1.2.0.192.in-addr.arpa." && question.Qtype == dns.TypePTR {
+			msg.Answer = append(msg.Answer, &dns.PTR{
+				Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 60},
+				Ptr: "host.example.",
+			})
+		} else {
+			msg.Rcode = dns.RcodeNameError
+		}
+
+		_ = w.WriteMsg(msg)
+	}))
+
+	// Addresses are taken in PTR mode whatever its case and with PTR among
+	// record types.
+	for _, task := range []*task{
+		{Mode: "PTR"},
+		{Mode: "ipv4", Types: []string{"ptr"}},
+	} {
+		task.Backend = "dns"
+		task.Files = []string{"addresses.lst"}
+		task.Output = "addresses.txt"
+		task.Format = "hosts"
+		task.Search = boolPtr(false)
+
+		settings := &settings{
+			dir:            dir,
+			LookupTimeout:  "1s",
+			Concurrency:    1,
+			Servers:        []string{server},
+			DaemonSettings: &daemonSettings{},
+		}
+
+		err = validateTask(task, settings)
+		require.Nil(t, err)
+		require.True(t, reverseTask(task))
+
+		err = performTask(context.Background(), task, settings)
+		require.Nil(t, err)
+
+		actual, err := getFilesAsString(path.Join(dir, "addresses.txt"))
+		require.Nil(t, err)
+		require.Equal(t, []string{"192.0.2.1 host.example\n"}, actual, task.Mode)
+	}
+}
+
+func TestCmdLineFlags(t *testing.T) {
+	// Every flag but these describes the task of the command line, which
+	// cannot be mixed with a config file.
+	for _, flag := range Flags {
+		name := flag.Names()[0]
+		if name == argConfig || name == argFail {
+			continue
+		}
+
+		require.Contains(t, argCmdLine, name)
+	}
+}
+
+// startSilentServer accepts queries and never answers them.
+func startSilentServer(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return conn.LocalAddr().String()
+}
+
+func TestTaskTimeout(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(path.Join(dir, "slow.lst"), []byte("slow.example\n"), 0o644)
+	require.Nil(t, err)
+
+	task := &task{
+		Files:  []string{"slow.lst"},
+		Output: "slow.txt",
+		Mode:   "ipv4",
+		Format: "list",
+		Search: boolPtr(false),
+	}
+
+	settings := &settings{
+		dir:           dir,
+		LookupTimeout: "5s",
+		TaskTimeout:   "100ms",
+		Concurrency:   1,
+		Servers:       []string{startSilentServer(t)},
+	}
+
+	start := time.Now()
+	err = performTask(context.Background(), task, settings)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+
+	_, err = os.Stat(path.Join(dir, "slow.txt"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestStreamTaskTimeout(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(path.Join(dir, "slow.lst"), []byte("ok.example\nslow.example\n"), 0o644)
+	require.Nil(t, err)
+	err = os.WriteFile(path.Join(dir, "slow.txt"), []byte("10.0.0.9\n"), 0o644)
+	require.Nil(t, err)
+
+	records := recordsHandler(map[string][]string{"ok.example.": {"10.0.0.1"}})
+
+	task := &task{
+		Files:  []string{"slow.lst"},
+		Output: "slow.txt",
+		Mode:   "ipv4",
+		Format: "list",
+		Search: boolPtr(false),
+		Stream: true,
+	}
+
+	settings := &settings{
+		dir:           dir,
+		LookupTimeout: "5s",
+		TaskTimeout:   "100ms",
+		Concurrency:   1,
+		Servers: []string{startServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			if req.Question[0].Name != "slow.example." {
+				records(w, req)
+			}
+		}))},
+	}
+
+	err = performTask(context.Background(), task, settings)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// ok.example was printed before the task timed out, yet the previous
+	// output is left as it was.
+	actual, err := getFilesAsString(path.Join(dir, "slow.txt"))
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.9\n"}, actual)
+
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	require.Len(t, entries, 2)
+}
+
+func TestDaemonStop(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(path.Join(dir, "slow.lst"), []byte("slow.example\n"), 0o644)
+	require.Nil(t, err)
+
+	config := &config{
+		Settings: &settings{
+			dir:           dir,
+			LookupTimeout: "5s",
+			Concurrency:   1,
+			Servers:       []string{startSilentServer(t)},
+			DaemonSettings: &daemonSettings{
+				Enabled:  true,
+				Interval: "1h",
+			},
+		},
+		Tasks: []task{
+			{
+				Files:  []string{"slow.lst"},
+				Output: "slow.txt",
+				Mode:   "ipv4",
+				Format: "list",
+				Search: boolPtr(false),
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	err = daemonMode(ctx, config)
+	require.Nil(t, err)
+	require.Less(t, time.Since(start), time.Second)
+
+	_, err = os.Stat(path.Join(dir, "slow.txt"))
+	require.True(t, os.IsNotExist(err))
+}
+
+// subnetHandler answers cdn.example. with addresses depending on the client
+// subnet of the query, like CDNs do.
+func subnetHandler() dns.HandlerFunc {
+	answers := map[string][]dns.RR{
+		"198.51.100.0/24": {newA("cdn.example.", "192.0.2.1"), newA("cdn.example.", "192.0.2.2")},
+		"203.0.113.0/24":  {newA("cdn.example.", "192.0.2.2"), newA("cdn.example.", "192.0.2.3")},
+	}
+
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(req)
+
+		subnet := ""
+		if opt := req.IsEdns0(); opt != nil {
+			for _, option := range opt.Option {
+				if ecs, ok := option.(*dns.EDNS0_SUBNET); ok {
+					subnet = fmt.Sprintf("%s/%d", ecs.Address, ecs.SourceNetmask)
+				}
+			}
+		}
+
+		msg.Answer = answers[subnet]
+
+		_ = w.WriteMsg(msg)
+	}
+}
+
+func TestTaskSubnets(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(path.Join(dir, "cdn.lst"), []byte("cdn.example\n"), 0o644)
+	require.Nil(t, err)
+
+	task := &task{
+		Files:   []string{"cdn.lst"},
+		Output:  "cdn.txt",
+		Mode:    "ipv4",
+		Format:  "template",
+		Search:  boolPtr(false),
+		Subnets: []string{"198.51.100.7", "203.0.113.0/24"},
+		Template: &printer.Template{
+			Text: "{{address}} {{subnet}}",
+		},
+	}
+	defaultValues(task)
+
+	settings := &settings{
+		dir:           dir,
+		LookupTimeout: "1s",
+		Servers:       []string{startServer(t, subnetHandler())},
+	}
+
+	err = validateTask(task, settings)
+	require.Nil(t, err)
+	require.Equal(t, []string{"198.51.100.0/24", "203.0.113.0/24"}, task.Subnets)
+
+	err = performTask(context.Background(), task, settings)
+	require.Nil(t, err)
+
+	actual, err := getFilesAsString(path.Join(dir, "cdn.txt"))
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		"192.0.2.1 198.51.100.0/24\n" +
+			"192.0.2.2 198.51.100.0/24\n" +
+			"192.0.2.2 203.0.113.0/24\n" +
+			"192.0.2.3 203.0.113.0/24\n",
+	}, actual)
+
+	task.SubnetMode = "union"
+	err = performTask(context.Background(), task, settings)
+	require.Nil(t, err)
+
+	actual, err = getFilesAsString(path.Join(dir, "cdn.txt"))
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		"192.0.2.1 198.51.100.0/24,203.0.113.0/24\n" +
+			"192.0.2.2 198.51.100.0/24,203.0.113.0/24\n" +
+			"192.0.2.3 198.51.100.0/24,203.0.113.0/24\n",
+	}, actual)
+
+	task.Subnets = []string{"198.51.100.0/33"}
+	require.NotNil(t, validateTask(task, settings))
+
+	task.Subnets = []string{"198.51.100.0/24"}
+	task.SubnetMode = "split"
+	require.NotNil(t, validateTask(task, settings))
+}
+
+// rotatingHandler answers lb.example. with one of the addresses at a time in
+// turn, like DNS load balancers do.
+func rotatingHandler(addresses ...string) dns.HandlerFunc {
+	answers := make([]dns.RR, 0, len(addresses))
+	for _, address := range addresses {
+		answers = append(answers, newA("lb.example.", address))
+	}
+
+	var mu sync.Mutex
+	next := 0
+
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(req)
+
+		mu.Lock()
+		msg.Answer = append(msg.Answer, answers[next%len(answers)])
+		next++
+		mu.Unlock()
+
+		_ = w.WriteMsg(msg)
+	}
+}
+
+func TestTaskSamples(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(path.Join(dir, "lb.lst"), []byte("lb.example\n"), 0o644)
+	require.Nil(t, err)
+
+	task := &task{
+		Files:          []string{"lb.lst"},
+		Output:         "lb.txt",
+		Mode:           "ipv4",
+		Format:         "template",
+		Search:         boolPtr(false),
+		Samples:        5,
+		SampleInterval: "1ms",
+		Template: &printer.Template{
+			Text: "{{address}} {{seen}}/{{samples}}",
+		},
+	}
+	defaultValues(task)
+
+	settings := &settings{
+		dir:           dir,
+		LookupTimeout: "1s",
+		Servers:       []string{startServer(t, rotatingHandler("192.0.2.1", "192.0.2.2", "192.0.2.3"))},
+	}
+
+	err = validateTask(task, settings)
+	require.Nil(t, err)
+
+	err = performTask(context.Background(), task, settings)
+	require.Nil(t, err)
+
+	actual, err := getFilesAsString(path.Join(dir, "lb.txt"))
+	require.Nil(t, err)
+	require.Equal(t, []string{"192.0.2.1 2/5\n192.0.2.2 2/5\n192.0.2.3 1/5\n"}, actual)
+
+	task.Samples = -1
+	require.NotNil(t, validateTask(task, settings))
+
+	task.Samples = 2
+	task.SampleInterval = "often"
+	require.NotNil(t, validateTask(task, settings))
+}
+
+func TestTaskDNSSEC(t *testing.T) {
+	task := &task{
+		Output: "dnssec.txt",
+		DNSSEC: "require",
+	}
+	defaultValues(task)
+
+	settings := &settings{
+		Concurrency:    1,
+		DaemonSettings: &daemonSettings{},
+		TrustAnchors: []string{
+			"example. 3600 IN DNSKEY 257 3 13 aRS/DcPWGQj2wVJydT8EcAVoC0kXn5pDVm2IMvDDPXeD32XzqVTK7tcgh6ZVqgNS+ccn7vL3J5qMnMYB1xbmkw==",
+		},
+	}
+
+	require.Nil(t, validateSettings(settings))
+	require.Len(t, settings.trustAnchors, 1)
+	require.Nil(t, validateTask(task, settings))
+
+	task.DNSSEC = "strict"
+	require.NotNil(t, validateTask(task, settings))
+
+	settings.TrustAnchors = []string{"example. 60 IN A 10.0.0.1"}
+	require.NotNil(t, validateSettings(settings))
+}
@@ -0,0 +1,193 @@
+package lookuper
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pabateman/dns-lookuper/internal/resolver"
+	v2 "github.com/pabateman/dns-lookuper/internal/resolver/v2"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	rcodePolicyIgnore = "ignore"
+	rcodePolicyWarn   = "warn"
+	rcodePolicyFail   = "fail"
+	rcodePolicyRetry  = "retry"
+
+	rcodePolicyDefault = rcodePolicyWarn
+)
+
+var rcodePolicyEnum = []string{
+	rcodePolicyIgnore,
+	rcodePolicyWarn,
+	rcodePolicyFail,
+	rcodePolicyRetry,
+}
+
+// parseRcodePolicies reads policies of the command line in RCODE=policy form.
+func parseRcodePolicies(args []string) (map[string]string, error) {
+	policies := make(map[string]string, len(args))
+
+	for _, arg := range args {
+		rcode, policy, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("rcode policy %s must be in RCODE=policy form", arg)
+		}
+		policies[rcode] = policy
+	}
+
+	return policies, nil
+}
+
+// validateRcodePolicies brings rcodes to upper case and checks them along
+// with their policies; NOERROR has no policy as its names are printed.
+func validateRcodePolicies(policies map[string]string) error {
+	for rcode, policy := range policies {
+		upper := strings.ToUpper(rcode)
+
+		code, ok := dns.StringToRcode[upper]
+		if !ok || code == dns.RcodeSuccess {
+			return fmt.Errorf("unsupported rcode %s in rcode policies", rcode)
+		}
+
+		if !slices.Contains(rcodePolicyEnum, policy) {
+			return fmt.Errorf("unsupported policy %s for rcode %s; valid policies are %s", policy, upper, rcodePolicyEnum)
+		}
+
+		delete(policies, rcode)
+		policies[upper] = policy
+	}
+
+	return nil
+}
+
+func rcodePolicy(s *settings, rcode string) string {
+	if policy, ok := s.Rcodes[rcode]; ok {
+		return policy
+	}
+
+	return rcodePolicyDefault
+}
+
+// retryRcodes resolves names answered with rcodes of the retry policy once
+// more, bypassing the cache, and puts new responses in place of old ones.
+func retryRcodes(ctx context.Context, r resolver.Resolver, responses []resolver.Response, s *settings) ([]resolver.Response, error) {
+	indexes := make([]int, 0)
+	names := make([]string, 0)
+
+	for i, response := range responses {
+		if response.Rcode != dns.RcodeToString[dns.RcodeSuccess] && rcodePolicy(s, response.Rcode) == rcodePolicyRetry {
+			indexes = append(indexes, i)
+			names = append(names, response.Name)
+		}
+	}
+
+	if len(names) == 0 {
+		return responses, nil
+	}
+
+	log.Infof("retrying %d names answered with %s", len(names), strings.Join(retriedRcodes(responses, indexes), ", "))
+
+	retried, err := r.Resolve(v2.BypassCache(ctx), names)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, index := range indexes {
+		responses[index] = retried[i]
+	}
+
+	return responses, nil
+}
+
+func retriedRcodes(responses []resolver.Response, indexes []int) []string {
+	rcodes := make([]string, 0, len(indexes))
+	for _, index := range indexes {
+		rcodes = append(rcodes, responses[index].Rcode)
+	}
+
+	slices.Sort(rcodes)
+	return slices.Compact(rcodes)
+}
+
+// reportRcodes logs names answered with rcodes other than NOERROR according
+// to their policies and fails with the number of names per rcode when any of
+// them is fatal.
+func reportRcodes(responses []resolver.Response, s *settings) error {
+	report := newRcodeReport(s)
+
+	for _, response := range responses {
+		report.add(response)
+	}
+
+	return report.err()
+}
+
+// rcodeReport counts names with fatal rcodes as they come. Rcodes still there
+// after a retry are reported like with warn; with --fail warnings are fatal
+// as well.
+type rcodeReport struct {
+	settings *settings
+	failed   map[string]int
+}
+
+func newRcodeReport(s *settings) *rcodeReport {
+	return &rcodeReport{
+		settings: s,
+		failed:   make(map[string]int),
+	}
+}
+
+func (r *rcodeReport) add(response resolver.Response) {
+	if response.Rcode == dns.RcodeToString[dns.RcodeSuccess] {
+		return
+	}
+
+	policy := rcodePolicy(r.settings, response.Rcode)
+	if policy == rcodePolicyRetry {
+		policy = rcodePolicyWarn
+	}
+	if policy == rcodePolicyWarn && r.settings.Fail {
+		policy = rcodePolicyFail
+	}
+
+	switch policy {
+	case rcodePolicyWarn:
+		log.Warnf("%s: %s", response.Name, rcodeMessage(response))
+	case rcodePolicyFail:
+		log.Errorf("%s: %s", response.Name, rcodeMessage(response))
+		r.failed[response.Rcode]++
+	}
+}
+
+func (r *rcodeReport) err() error {
+	if len(r.failed) == 0 {
+		return nil
+	}
+
+	summary := make([]string, 0, len(r.failed))
+	for _, rcode := range slices.Sorted(maps.Keys(r.failed)) {
+		summary = append(summary, fmt.Sprintf("%d %s", r.failed[rcode], rcode))
+	}
+
+	return fmt.Errorf("encountered errors while resolving domain names: %s", strings.Join(summary, ", "))
+}
+
+func rcodeMessage(r resolver.Response) string {
+	if r.Rcode == dns.RcodeToString[dns.RcodeNameError] {
+		return "no such host"
+	}
+
+	message := fmt.Sprintf("answered with %s", r.Rcode)
+	if len(r.Errors) > 0 {
+		message = fmt.Sprintf("%s: %s", message, strings.Join(r.Errors, "; "))
+	}
+
+	return message
+}
@@ -0,0 +1,221 @@
+package lookuper
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+	v2 "github.com/pabateman/dns-lookuper/internal/resolver/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// rcodeServer answers names with the rcodes of the map and counts queries
+// per name; flaky.example. gets SERVFAIL for the first failures queries and
+// then the address like ok.example.
+type rcodeServer struct {
+	mu       sync.Mutex
+	failures int
+	queries  map[string]int
+}
+
+func newRcodeServer(failures int) *rcodeServer {
+	return &rcodeServer{
+		failures: failures,
+		queries:  make(map[string]int),
+	}
+}
+
+func (s *rcodeServer) count(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.queries[name]
+}
+
+func (s *rcodeServer) handle(w dns.ResponseWriter, req *dns.Msg) {
+	rcodes := map[string]int{
+		"ok.example.":      dns.RcodeSuccess,
+		"refused.example.": dns.RcodeRefused,
+		"missing.example.": dns.RcodeNameError,
+	}
+
+	answers := map[string]dns.RR{
+		"ok.example.":    newA("ok.example.", "10.0.0.1"),
+		"flaky.example.": newA("flaky.example.", "10.0.0.1"),
+	}
+
+	msg := new(dns.Msg)
+	msg.SetReply(req)
+
+	name := req.Question[0].Name
+	rcode := rcodes[name]
+
+	s.mu.Lock()
+	s.queries[name]++
+	if name == "flaky.example." && s.queries[name] <= s.failures {
+		rcode = dns.RcodeServerFailure
+	}
+	s.mu.Unlock()
+
+	msg.Rcode = rcode
+	if rr, ok := answers[name]; ok && rcode == dns.RcodeSuccess && req.Question[0].Qtype == dns.TypeA {
+		msg.Answer = append(msg.Answer, rr)
+	}
+
+	// The SOA makes negative answers cacheable.
+	if rcode == dns.RcodeNameError {
+		soa, _ := dns.NewRR("example. 300 IN SOA ns1.example. hostmaster.example. 1 7200 3600 1209600 300")
+		msg.Ns = append(msg.Ns, soa)
+	}
+
+	_ = w.WriteMsg(msg)
+}
+
+func TestRcodePolicies(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(path.Join(dir, "rcodes.lst"), []byte("ok.example\nflaky.example\nrefused.example\nmissing.example\n"), 0o644)
+	require.Nil(t, err)
+
+	// flaky.example. fails every attempt of the first query, so only the
+	// retry policy brings it to the output.
+	server := newRcodeServer(2)
+
+	task := &task{
+		Backend: "dns",
+		Files:   []string{"rcodes.lst"},
+		Output:  "rcodes.txt",
+		Mode:    "ipv4",
+		Format:  "hosts",
+		Search:  boolPtr(false),
+	}
+
+	settings := &settings{
+		dir:           dir,
+		LookupTimeout: "1s",
+		Concurrency:   1,
+		Attempts:      2,
+		Servers:       []string{startServer(t, dns.HandlerFunc(server.handle))},
+		Rcodes: map[string]string{
+			"servfail": "retry",
+			"REFUSED":  "fail",
+		},
+	}
+
+	err = validateRcodePolicies(settings.Rcodes)
+	require.Nil(t, err)
+	require.Equal(t, map[string]string{"SERVFAIL": "retry", "REFUSED": "fail"}, settings.Rcodes)
+
+	err = performTask(context.Background(), task, settings)
+	require.EqualError(t, err, "encountered errors while resolving domain names: 1 REFUSED")
+	require.Equal(t, 3, server.count("flaky.example."))
+
+	settings.Rcodes["REFUSED"] = "ignore"
+	err = performTask(context.Background(), task, settings)
+	require.Nil(t, err)
+
+	actual, err := getFilesAsString(path.Join(dir, "rcodes.txt"))
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1 flaky.example\n10.0.0.1 ok.example\n"}, actual)
+
+	settings.Fail = true
+	err = performTask(context.Background(), task, settings)
+	require.EqualError(t, err, "encountered errors while resolving domain names: 1 NXDOMAIN")
+
+	settings.Rcodes["REFUSED"] = "warn"
+	err = performTask(context.Background(), task, settings)
+	require.EqualError(t, err, "encountered errors while resolving domain names: 1 NXDOMAIN, 1 REFUSED")
+
+	// Retries query servers again instead of taking the cached NXDOMAIN.
+	settings.Fail = false
+	settings.cache = v2.NewCache()
+	settings.Rcodes["NXDOMAIN"] = "retry"
+	queries := server.count("missing.example.")
+	err = performTask(context.Background(), task, settings)
+	require.Nil(t, err)
+	require.Equal(t, queries+2, server.count("missing.example."))
+}
+
+func TestRcodePoliciesInvalid(t *testing.T) {
+	_, err := parseRcodePolicies([]string{"SERVFAIL"})
+	require.NotNil(t, err)
+
+	policies, err := parseRcodePolicies([]string{"SERVFAIL=retry", "notimp=ignore"})
+	require.Nil(t, err)
+	require.Nil(t, validateRcodePolicies(policies))
+	require.Equal(t, map[string]string{"SERVFAIL": "retry", "NOTIMP": "ignore"}, policies)
+
+	require.NotNil(t, validateRcodePolicies(map[string]string{"NOERROR": "warn"}))
+	require.NotNil(t, validateRcodePolicies(map[string]string{"BOGUS": "warn"}))
+	require.NotNil(t, validateRcodePolicies(map[string]string{"SERVFAIL": "panic"}))
+}
+
+func TestRcodePoliciesStream(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(path.Join(dir, "rcodes.lst"), []byte("ok.example\nflaky.example\nrefused.example\nmissing.example\n"), 0o644)
+	require.Nil(t, err)
+
+	// flaky.example. fails every attempt of the first query, so only the
+	// retry policy brings it to the output.
+	server := newRcodeServer(2)
+
+	task := &task{
+		Backend: "dns",
+		Files:   []string{"rcodes.lst"},
+		Output:  "rcodes.txt",
+		Mode:    "ipv4",
+		Format:  "hosts",
+		Search:  boolPtr(false),
+		Stream:  true,
+	}
+
+	settings := &settings{
+		dir:           dir,
+		LookupTimeout: "1s",
+		Concurrency:   2,
+		Attempts:      2,
+		Servers:       []string{startServer(t, dns.HandlerFunc(server.handle))},
+		Rcodes: map[string]string{
+			"SERVFAIL": "retry",
+			"REFUSED":  "fail",
+		},
+	}
+
+	err = performTask(context.Background(), task, settings)
+	require.EqualError(t, err, "encountered errors while resolving domain names: 1 REFUSED")
+	require.Equal(t, 3, server.count("flaky.example."))
+
+	// Streamed names keep the order of the list.
+	actual, err := getFilesAsString(path.Join(dir, "rcodes.txt"))
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1 ok.example\n10.0.0.1 flaky.example\n"}, actual)
+
+	settings.Rcodes["REFUSED"] = "ignore"
+	task.Format = "jsonl"
+	err = performTask(context.Background(), task, settings)
+	require.Nil(t, err)
+
+	actual, err = getFilesAsString(path.Join(dir, "rcodes.txt"))
+	require.Nil(t, err)
+	require.Len(t, strings.Split(strings.TrimSpace(actual[0]), "\n"), 2)
+
+	// Invalid names show up while the list is streamed; with --fail they
+	// fail the task without replacing the output.
+	err = os.WriteFile(path.Join(dir, "rcodes.lst"), []byte("ok.example\nnot_a_name!\n"), 0o644)
+	require.Nil(t, err)
+
+	settings.Fail = true
+	err = performTask(context.Background(), task, settings)
+	require.EqualError(t, err, "error while parsing domain names")
+
+	replaced, err := getFilesAsString(path.Join(dir, "rcodes.txt"))
+	require.Nil(t, err)
+	require.Equal(t, actual, replaced)
+
+	task.Format = "yaml"
+	settings.DaemonSettings = &daemonSettings{}
+	require.NotNil(t, validateTask(task, settings))
+}
@@ -0,0 +1,65 @@
+package lookuper
+
+import (
+	"fmt"
+
+	"github.com/pabateman/dns-lookuper/internal/resolver"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var wildcardPolicyEnum = []string{
+	rcodePolicyIgnore,
+	rcodePolicyWarn,
+	rcodePolicyFail,
+}
+
+// wildcardReport counts names answered by wildcard records as they come; the
+// names are printed anyway, so with ignore they are only flagged in output.
+type wildcardReport struct {
+	policy string
+	failed int
+}
+
+func newWildcardReport(t *task, s *settings) *wildcardReport {
+	policy := t.Wildcard
+	if policy == rcodePolicyWarn && s.Fail {
+		policy = rcodePolicyFail
+	}
+
+	return &wildcardReport{
+		policy: policy,
+	}
+}
+
+func (r *wildcardReport) add(response resolver.Response) {
+	if !response.Wildcard {
+		return
+	}
+
+	switch r.policy {
+	case rcodePolicyWarn:
+		log.Warnf("%s: answered by a wildcard record", response.Name)
+	case rcodePolicyFail:
+		log.Errorf("%s: answered by a wildcard record", response.Name)
+		r.failed++
+	}
+}
+
+func (r *wildcardReport) err() error {
+	if r.failed == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("encountered %d names answered by wildcard records", r.failed)
+}
+
+func reportWildcards(responses []resolver.Response, t *task, s *settings) error {
+	report := newWildcardReport(t, s)
+
+	for _, response := range responses {
+		report.add(response)
+	}
+
+	return report.err()
+}
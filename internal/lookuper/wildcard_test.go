@@ -0,0 +1,85 @@
+package lookuper
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/pabateman/dns-lookuper/internal/printer"
+	"github.com/stretchr/testify/require"
+)
+
+// wildcardHandler answers any name under wild.example with 10.0.0.9 but
+// api.wild.example, which has an address of its own.
+func wildcardHandler(w dns.ResponseWriter, req *dns.Msg) {
+	msg := new(dns.Msg)
+	msg.SetReply(req)
+
+	name := req.Question[0].Name
+	address := "10.0.0.9"
+	if name == "api.wild.example." {
+		address = "10.0.0.1"
+	}
+
+	if strings.HasSuffix(name, ".wild.example.") {
+		msg.Answer = append(msg.Answer, newA(name, address))
+	} else {
+		msg.Rcode = dns.RcodeNameError
+	}
+
+	_ = w.WriteMsg(msg)
+}
+
+func TestWildcardPolicies(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(path.Join(dir, "wildcard.lst"), []byte("api.wild.example\nwww.wild.example\n"), 0o644)
+	require.Nil(t, err)
+
+	task := &task{
+		Backend:  "dns",
+		Files:    []string{"wildcard.lst"},
+		Output:   "wildcard.txt",
+		Mode:     "ipv4",
+		Format:   "template",
+		Search:   boolPtr(false),
+		Wildcard: "fail",
+		Template: &printer.Template{
+			Text: "{{host}} {{address}} {{wildcard}}",
+		},
+	}
+
+	settings := &settings{
+		dir:           dir,
+		LookupTimeout: "1s",
+		Servers:       []string{startServer(t, dns.HandlerFunc(wildcardHandler))},
+	}
+
+	err = validateTask(task, settings)
+	require.Nil(t, err)
+
+	err = performTask(context.Background(), task, settings)
+	require.EqualError(t, err, "encountered 1 names answered by wildcard records")
+
+	task.Wildcard = "warn"
+	err = performTask(context.Background(), task, settings)
+	require.Nil(t, err)
+
+	actual, err := getFilesAsString(path.Join(dir, "wildcard.txt"))
+	require.Nil(t, err)
+	require.Equal(t, []string{"api.wild.example 10.0.0.1 false\nwww.wild.example 10.0.0.9 true\n"}, actual)
+
+	task.Stream = true
+	settings.Fail = true
+	err = performTask(context.Background(), task, settings)
+	require.EqualError(t, err, "encountered 1 names answered by wildcard records")
+
+	task.Wildcard = "bogus"
+	require.NotNil(t, validateTask(task, settings))
+
+	task.Wildcard = "warn"
+	task.Backend = "system"
+	require.NotNil(t, validateTask(task, settings))
+}
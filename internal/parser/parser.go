@@ -2,6 +2,8 @@ package parser
 
 import (
 	"bufio"
+	"iter"
+	"net/netip"
 	"os"
 	"slices"
 	"strings"
@@ -9,48 +11,156 @@ import (
 	"github.com/asaskevich/govalidator"
 )
 
+// MaxNetworkHostBits limits networks accepted in reverse mode to 256
+// addresses, e.g. /24 for IPv4 and /120 for IPv6.
+const MaxNetworkHostBits = 8
+
 type DomainNames struct {
 	ParsedNames   []string
 	UnparsedNames map[string][]string
+	reverse       bool
+	err           error
 }
 
 func NewDomainNames() *DomainNames {
 	return &DomainNames{
 		make([]string, 0),
 		make(map[string][]string),
+		false,
+		nil,
 	}
 }
 
+// WithReverse makes the parser accept IP addresses and small networks instead
+// of domain names; networks are expanded to their addresses.
+func (d *DomainNames) WithReverse(r bool) *DomainNames {
+	d.reverse = r
+	return d
+}
+
+// Reverse tells whether the parser takes IP addresses instead of names.
+func (d *DomainNames) Reverse() bool {
+	return d.reverse
+}
+
+// ParseFile adds names of the file to ParsedNames, keeping them sorted and
+// without duplicates.
 func (d *DomainNames) ParseFile(path string) error {
-	file, err := os.Open(path)
-	if err != nil {
-		return err
+	for name, err := range d.names(path) {
+		if err != nil {
+			return err
+		}
+
+		d.ParsedNames = append(d.ParsedNames, name)
+	}
+
+	if d.reverse {
+		slices.SortFunc(d.ParsedNames, func(a, b string) int {
+			return netip.MustParseAddr(a).Compare(netip.MustParseAddr(b))
+		})
+	} else {
+		slices.Sort(d.ParsedNames)
 	}
-	defer file.Close()
+	d.ParsedNames = slices.Compact(d.ParsedNames)
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		for _, name := range strings.Split(scanner.Text(), " ") {
+	return nil
+}
 
-			if strings.HasPrefix(name, "#") {
-				break
-			}
+// Stream yields names of the files line by line in the order they are
+// written, duplicates included, so lists never have to fit in memory. A file
+// that cannot be read stops the stream; Err returns its error then.
+func (d *DomainNames) Stream(paths []string) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for _, path := range paths {
+			for name, err := range d.names(path) {
+				if err != nil {
+					d.err = err
+					return
+				}
 
-			if name == "" {
-				continue
+				if !yield(name) {
+					return
+				}
 			}
+		}
+	}
+}
+
+// Err returns the error that stopped the last stream.
+func (d *DomainNames) Err() error {
+	return d.err
+}
 
-			if !govalidator.IsDNSName(name) {
-				d.UnparsedNames[path] = append(d.UnparsedNames[path], name)
-				continue
+// names yields valid names of the file as they are read and puts invalid
+// ones in UnparsedNames; the error of reading the file comes last.
+func (d *DomainNames) names(path string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		file, err := os.Open(path)
+		if err != nil {
+			yield("", err)
+			return
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			for _, name := range strings.Split(scanner.Text(), " ") {
+
+				if strings.HasPrefix(name, "#") {
+					break
+				}
+
+				if name == "" {
+					continue
+				}
+
+				if d.reverse {
+					addresses, ok := parseAddresses(name)
+					if !ok {
+						d.UnparsedNames[path] = append(d.UnparsedNames[path], name)
+						continue
+					}
+
+					for _, address := range addresses {
+						if !yield(address, nil) {
+							return
+						}
+					}
+					continue
+				}
+
+				if !govalidator.IsDNSName(name) {
+					d.UnparsedNames[path] = append(d.UnparsedNames[path], name)
+					continue
+				}
+
+				if !yield(name, nil) {
+					return
+				}
 			}
+		}
 
-			d.ParsedNames = append(d.ParsedNames, name)
+		if err := scanner.Err(); err != nil {
+			yield("", err)
 		}
 	}
+}
 
-	slices.Sort(d.ParsedNames)
-	d.ParsedNames = slices.Compact(d.ParsedNames)
+func parseAddresses(s string) ([]string, bool) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return []string{addr.WithZone("").String()}, true
+	}
 
-	return nil
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil || prefix.Addr().BitLen()-prefix.Bits() > MaxNetworkHostBits {
+		return nil, false
+	}
+
+	result := make([]string, 0)
+	prefix = prefix.Masked()
+	for addr := prefix.Addr(); addr.IsValid() && prefix.Contains(addr); addr = addr.Next() {
+		result = append(result, addr.String())
+	}
+
+	return result, true
 }
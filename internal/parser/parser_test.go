@@ -3,6 +3,7 @@ package parser
 import (
 	"path"
 	"reflect"
+	"slices"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -101,3 +102,69 @@ func TestParserNonExistingFile(t *testing.T) {
 	err := input.ParseFile(path.Join(testDataPath, "lists/this_file_does_not_exist.lst"))
 	require.Error(t, err)
 }
+
+func TestParserReverse(t *testing.T) {
+	addressesPath := path.Join(testDataPath, "lists/addresses.lst")
+
+	input := NewDomainNames().WithReverse(true)
+	err := input.ParseFile(addressesPath)
+	require.NoError(t, err)
+
+	expected := NewDomainNames()
+	expected.ParsedNames = []string{
+		"10.0.0.1",
+		"192.0.2.0",
+		"192.0.2.1",
+		"192.0.2.2",
+		"192.0.2.3",
+		"2001:db8::",
+		"2001:db8::1",
+	}
+	expected.UnparsedNames[addressesPath] = []string{
+		"10.0.0.0/8",
+		"example.com",
+	}
+
+	require.True(t, reflect.DeepEqual(input.ParsedNames, expected.ParsedNames))
+	require.True(t, reflect.DeepEqual(input.UnparsedNames, expected.UnparsedNames))
+
+	input = NewDomainNames()
+	err = input.ParseFile(addressesPath)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"example.com"}, input.ParsedNames)
+	require.Len(t, input.UnparsedNames[addressesPath], 5)
+}
+
+func TestParserStream(t *testing.T) {
+	paths := []string{
+		path.Join(testDataPath, "lists/1.lst"),
+		path.Join(testDataPath, "lists/2.lst"),
+	}
+
+	input := NewDomainNames()
+	names := slices.Collect(input.Stream(paths))
+	require.NoError(t, input.Err())
+
+	require.Equal(t, []string{
+		"hashicorp.com",
+		"terraform.io",
+		"cloudflare.com",
+		"releases.hashicorp.com",
+		"rpm.releases.hashicorp.com",
+		"google.com",
+		"linked.in",
+		"hashicorp.com",
+		"terraform.io",
+	}, names)
+
+	for name := range input.Stream(paths) {
+		require.Equal(t, "hashicorp.com", name)
+		break
+	}
+
+	input = NewDomainNames()
+	names = slices.Collect(input.Stream([]string{paths[0], path.Join(testDataPath, "lists/this_file_does_not_exist.lst")}))
+	require.Len(t, names, 3)
+	require.Error(t, input.Err())
+}
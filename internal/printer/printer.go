@@ -4,29 +4,36 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"iter"
 	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/ghodss/yaml"
 	"github.com/valyala/fasttemplate"
 
-	"github.com/pabateman/dns-lookuper/internal/resolver/v2"
+	"github.com/pabateman/dns-lookuper/internal/resolver"
 )
 
 const (
 	FormatJSON     = "json"
+	FormatJSONL    = "jsonl"
 	FormatYAML     = "yaml"
 	FormatCSV      = "csv"
 	FormatHosts    = "hosts"
 	FormatList     = "list"
 	FormatTemplate = "template"
+	FormatDiff     = "diff"
 	FormatDefault  = FormatHosts
 )
 
 type Printer struct {
-	template *Template
-	entries  []resolver.Response
-	writer   io.Writer
-	fn       func() error
+	template    *Template
+	entries     []resolver.Response
+	stream      iter.Seq2[resolver.Response, error]
+	comparisons []resolver.Comparison
+	writer      io.Writer
+	fn          func() error
 }
 
 type Template struct {
@@ -70,8 +77,26 @@ func (p *Printer) WithEntries(e []resolver.Response) *Printer {
 	return p
 }
 
+// WithStream makes the printer take entries from the stream and write them as
+// they come. The list format prints addresses in the order of the stream
+// dropping duplicates of a name only, json and yaml collect the stream first.
+// An error of the stream stops printing and is returned by Print.
+func (p *Printer) WithStream(s iter.Seq2[resolver.Response, error]) *Printer {
+	p.stream = s
+	return p
+}
+
+// WithComparisons makes json and yaml formats print comparisons instead of
+// entries; the diff format prints only them.
+func (p *Printer) WithComparisons(c []resolver.Comparison) *Printer {
+	p.comparisons = c
+	return p
+}
+
 func (p *Printer) WithFormat(f string) *Printer {
 	switch f {
+	case FormatDiff:
+		p.fn = p.printDiff
 	case FormatTemplate:
 		p.fn = p.printTemplate
 	case FormatList:
@@ -81,6 +106,8 @@ func (p *Printer) WithFormat(f string) *Printer {
 		p.fn = p.printTemplate
 	case FormatJSON:
 		p.fn = p.printJSON
+	case FormatJSONL:
+		p.fn = p.printJSONL
 	case FormatYAML:
 		p.fn = p.printYAML
 	case FormatCSV:
@@ -119,12 +146,13 @@ func (p *Printer) printTemplate() error {
 	}
 
 	if p.template.Text != "" {
-		for _, response := range p.entries {
-			for _, address := range response.Addresses {
-				s := t.ExecuteString(map[string]interface{}{
-					"host":    response.Name,
-					"address": address,
-				})
+		for response, err := range p.responses() {
+			if err != nil {
+				return err
+			}
+
+			for _, vars := range templateVars(response) {
+				s := t.ExecuteString(vars)
 
 				if _, err := io.WriteString(p.writer, fmt.Sprintln(s)); err != nil {
 					return err
@@ -142,10 +170,16 @@ func (p *Printer) printTemplate() error {
 }
 
 func (p *Printer) printList() error {
+	if p.stream != nil {
+		return p.printListStream()
+	}
+
 	addresses := make([]string, 0)
 
 	for _, response := range p.entries {
-		addresses = append(addresses, response.Addresses...)
+		for _, vars := range templateVars(response) {
+			addresses = append(addresses, vars[varValue].(string))
+		}
 	}
 
 	slices.Sort(addresses)
@@ -161,8 +195,37 @@ func (p *Printer) printList() error {
 	return nil
 }
 
+func (p *Printer) printListStream() error {
+	for response, err := range p.stream {
+		if err != nil {
+			return err
+		}
+
+		addresses := make([]string, 0)
+		for _, vars := range templateVars(response) {
+			address := vars[varValue].(string)
+			if !slices.Contains(addresses, address) {
+				addresses = append(addresses, address)
+			}
+		}
+
+		for _, address := range addresses {
+			if _, err := io.WriteString(p.writer, fmt.Sprintln(address)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 func (p *Printer) printJSON() error {
-	encoded, err := json.MarshalIndent(p.entries, "", "  ")
+	data, err := p.data()
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -178,8 +241,34 @@ func (p *Printer) printJSON() error {
 	return nil
 }
 
+// printJSONL prints an entry per line as described by JSON Lines.
+func (p *Printer) printJSONL() error {
+	for response, err := range p.responses() {
+		if err != nil {
+			return err
+		}
+
+		encoded, err := json.Marshal(response)
+		if err != nil {
+			return err
+		}
+
+		encoded = append(encoded, byte('\n'))
+		if _, err := p.writer.Write(encoded); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (p *Printer) printYAML() error {
-	encoded, err := yaml.Marshal(p.entries)
+	data, err := p.data()
+	if err != nil {
+		return err
+	}
+
+	encoded, err := yaml.Marshal(data)
 	if err != nil {
 		return err
 	}
@@ -191,3 +280,87 @@ func (p *Printer) printYAML() error {
 
 	return nil
 }
+
+func (p *Printer) data() (interface{}, error) {
+	if p.comparisons != nil {
+		return p.comparisons, nil
+	}
+
+	if p.stream != nil {
+		return resolver.Collect(p.stream)
+	}
+
+	return p.entries, nil
+}
+
+// responses returns the stream or entries in the form of one.
+func (p *Printer) responses() iter.Seq2[resolver.Response, error] {
+	if p.stream != nil {
+		return p.stream
+	}
+
+	return func(yield func(resolver.Response, error) bool) {
+		for _, response := range p.entries {
+			if !yield(response, nil) {
+				return
+			}
+		}
+	}
+}
+
+// printDiff prints a line per name with values all servers agree on, marked
+// with "=" when servers agree and with "!" otherwise. Divergent names are
+// followed by a line per server with the rcode, values only some of servers
+// returned ("+") and values returned by others but missing here ("-").
+func (p *Printer) printDiff() error {
+	for _, comparison := range p.comparisons {
+		mark := "="
+		if comparison.Divergent {
+			mark = "!"
+		}
+
+		line := append([]string{mark, comparison.Name}, quoteValues(comparison.Matching, "")...)
+		if _, err := io.WriteString(p.writer, fmt.Sprintln(strings.Join(line, " "))); err != nil {
+			return err
+		}
+
+		if !comparison.Divergent {
+			continue
+		}
+
+		for _, view := range comparison.Views {
+			extra := make([]string, 0)
+			for _, value := range view.Values {
+				if !slices.Contains(comparison.Matching, value) {
+					extra = append(extra, value)
+				}
+			}
+
+			line := slices.Concat(
+				[]string{"   ", view.Server, view.Rcode},
+				quoteValues(extra, "+"),
+				quoteValues(view.Missing, "-"),
+			)
+			if _, err := io.WriteString(p.writer, fmt.Sprintln(strings.Join(line, " "))); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// quoteValues prefixes values and quotes the ones with spaces, like MX or SRV
+// data, so they stay apart on the line.
+func quoteValues(values []string, prefix string) []string {
+	result := make([]string, 0, len(values))
+
+	for _, value := range values {
+		if strings.ContainsRune(value, ' ') {
+			value = strconv.Quote(value)
+		}
+		result = append(result, prefix+value)
+	}
+
+	return result
+}
@@ -6,8 +6,9 @@ import (
 	"os"
 	"path"
 	"testing"
+	"time"
 
-	"github.com/pabateman/dns-lookuper/internal/resolver/v2"
+	"github.com/pabateman/dns-lookuper/internal/resolver"
 	"github.com/stretchr/testify/require"
 )
 
@@ -216,3 +217,368 @@ func TestPrinterTemplate(t *testing.T) {
 
 	require.Equal(t, expected, b.String())
 }
+
+func TestPrinterCNAMEs(t *testing.T) {
+	var b bytes.Buffer
+
+	p := NewPrinter().
+		WithEntries([]resolver.Response{
+			{
+				Name:      "www",
+				FQDN:      "www.example.com",
+				Addresses: []string{"10.0.0.1", "10.0.0.2"},
+				CNAMEs:    []string{"www.example.com.edge.net", "e1.cdn.net"},
+			},
+			{
+				Name:      "example.com",
+				Addresses: []string{"10.0.0.3"},
+			},
+		}).
+		WithOutput(&b).
+		WithFormat(FormatTemplate).
+		WithTemplate(&Template{
+			Text: "{{host}} ({{fqdn}}) {{address}} via [{{cnames}}]",
+		})
+
+	err := p.Print()
+	require.Nil(t, err)
+
+	expected, err := getExpected(path.Join(expectedContentDirectory, "template_cnames.txt"))
+	require.Nil(t, err)
+
+	require.Equal(t, expected, b.String())
+
+	b.Reset()
+	p.WithFormat(FormatJSON)
+	err = p.Print()
+	require.Nil(t, err)
+
+	expected, err = getExpected(path.Join(expectedContentDirectory, "json_cnames.json"))
+	require.Nil(t, err)
+
+	require.Equal(t, expected, b.String())
+}
+
+func TestPrinterRecordTypes(t *testing.T) {
+	var b bytes.Buffer
+
+	p := NewPrinter().
+		WithEntries([]resolver.Response{
+			{
+				Name:      "example.com",
+				Addresses: []string{"10.0.0.1", "fd00::1"},
+				MX: []resolver.MX{
+					{Priority: 10, Target: "mx1.example.com"},
+				},
+				TXT: []string{"v=spf1 -all"},
+				CAA: []resolver.CAA{
+					{Flag: 0, Tag: "issue", Value: "letsencrypt.org"},
+				},
+				SOA: &resolver.SOA{
+					Mname:   "ns1.example.com",
+					Rname:   "hostmaster.example.com",
+					Serial:  2024010101,
+					Refresh: 7200,
+					Retry:   3600,
+					Expire:  1209600,
+					Minttl:  300,
+				},
+				Records: []resolver.Record{
+					{Type: "HINFO", Value: "\"amd64\" \"linux\""},
+				},
+			},
+			{
+				Name: "_sip._tcp.example.com",
+				SRV: []resolver.SRV{
+					{Priority: 10, Weight: 60, Port: 5060, Target: "sip.example.com"},
+				},
+			},
+		}).
+		WithOutput(&b).
+		WithFormat(FormatTemplate).
+		WithTemplate(&Template{
+			Text: "{{type}} {{host}}: {{value}} [{{priority}}|{{weight}}|{{port}}|{{target}}|{{tag}}|{{serial}}]",
+		})
+
+	err := p.Print()
+	require.Nil(t, err)
+
+	expected, err := getExpected(path.Join(expectedContentDirectory, "template_records.txt"))
+	require.Nil(t, err)
+
+	require.Equal(t, expected, b.String())
+
+	b.Reset()
+	p.WithFormat(FormatYAML)
+	err = p.Print()
+	require.Nil(t, err)
+
+	expected, err = getExpected(path.Join(expectedContentDirectory, "yaml_records.yaml"))
+	require.Nil(t, err)
+
+	require.Equal(t, expected, b.String())
+}
+
+func TestPrinterSVCB(t *testing.T) {
+	var b bytes.Buffer
+
+	p := NewPrinter().
+		WithEntries([]resolver.Response{
+			{
+				Name:      "svc.example.com",
+				Addresses: []string{"192.0.2.1", "2001:db8::1"},
+				Provenance: map[string]string{
+					"192.0.2.1":   "HTTPS pool.example.com ipv4hint",
+					"2001:db8::1": "HTTPS pool.example.com ipv6hint",
+				},
+				SVCB: []resolver.SVCB{
+					{Type: "HTTPS", Priority: 0, Target: "pool.example.com"},
+					{Type: "HTTPS", Priority: 1, Target: ".", Params: map[string]string{
+						"alpn":     "h3,h2",
+						"port":     "8443",
+						"ipv4hint": "192.0.2.1",
+						"ipv6hint": "2001:db8::1",
+					}},
+				},
+			},
+		}).
+		WithOutput(&b).
+		WithFormat(FormatTemplate).
+		WithTemplate(&Template{
+			Text: "{{type}} {{host}}: {{value}} [{{priority}}|{{target}}|{{alpn}}|{{port}}|{{params}}|{{provenance}}]",
+		})
+
+	err := p.Print()
+	require.Nil(t, err)
+
+	expected, err := getExpected(path.Join(expectedContentDirectory, "template_svcb.txt"))
+	require.Nil(t, err)
+
+	require.Equal(t, expected, b.String())
+
+	b.Reset()
+	p.WithFormat(FormatJSON)
+	err = p.Print()
+	require.Nil(t, err)
+
+	expected, err = getExpected(path.Join(expectedContentDirectory, "json_svcb.json"))
+	require.Nil(t, err)
+
+	require.Equal(t, expected, b.String())
+}
+
+func TestPrinterReverse(t *testing.T) {
+	var b bytes.Buffer
+
+	p := NewPrinter().
+		WithEntries([]resolver.Response{
+			{
+				Name: "10.0.0.1",
+				PTR:  []string{"host.example.com", "alias.example.com"},
+			},
+			{
+				Name: "fd00::1",
+				PTR:  []string{"host6.example.com"},
+			},
+		}).
+		WithOutput(&b).
+		WithFormat(FormatHosts)
+
+	err := p.Print()
+	require.Nil(t, err)
+
+	expected, err := getExpected(path.Join(expectedContentDirectory, "hosts_reverse"))
+	require.Nil(t, err)
+
+	require.Equal(t, expected, b.String())
+
+	b.Reset()
+	p.WithFormat(FormatCSV)
+	err = p.Print()
+	require.Nil(t, err)
+
+	expected, err = getExpected(path.Join(expectedContentDirectory, "csv_reverse.csv"))
+	require.Nil(t, err)
+
+	require.Equal(t, expected, b.String())
+
+	b.Reset()
+	p.WithFormat(FormatList)
+	err = p.Print()
+	require.Nil(t, err)
+
+	expected, err = getExpected(path.Join(expectedContentDirectory, "list_reverse.txt"))
+	require.Nil(t, err)
+
+	require.Equal(t, expected, b.String())
+}
+
+func TestPrinterMetadata(t *testing.T) {
+	var b bytes.Buffer
+
+	timestamp := time.Date(2024, time.March, 1, 12, 30, 0, 0, time.UTC)
+
+	p := NewPrinter().
+		WithEntries([]resolver.Response{
+			{
+				Name:      "example.com",
+				Addresses: []string{"10.0.0.1"},
+				Rcode:     "NOERROR",
+				TTLMin:    uint32Ptr(60),
+				TTLMax:    uint32Ptr(300),
+				Server:    "10.0.0.53:53",
+				RTT:       resolver.Duration(1500 * time.Microsecond),
+				Timestamp: &timestamp,
+				AD:        true,
+				DNSSEC:    "secure",
+				Wildcard:  true,
+				Samples:   3,
+				Appearances: map[string]int{
+					"10.0.0.1": 2,
+				},
+			},
+		}).
+		WithOutput(&b).
+		WithFormat(FormatTemplate).
+		WithTemplate(&Template{
+			Text: "{{timestamp}} {{host}} {{address}} {{rcode}} ttl={{ttlMin}}..{{ttlMax}} from {{server}} in {{rtt}} ad={{ad}} dnssec={{dnssec}} wildcard={{wildcard}} seen={{seen}}/{{samples}}",
+		})
+
+	err := p.Print()
+	require.Nil(t, err)
+
+	expected, err := getExpected(path.Join(expectedContentDirectory, "template_metadata.txt"))
+	require.Nil(t, err)
+
+	require.Equal(t, expected, b.String())
+
+	b.Reset()
+	p.WithFormat(FormatJSON)
+	err = p.Print()
+	require.Nil(t, err)
+
+	expected, err = getExpected(path.Join(expectedContentDirectory, "json_metadata.json"))
+	require.Nil(t, err)
+
+	require.Equal(t, expected, b.String())
+
+	b.Reset()
+	p.WithFormat(FormatYAML)
+	err = p.Print()
+	require.Nil(t, err)
+
+	expected, err = getExpected(path.Join(expectedContentDirectory, "yaml_metadata.yaml"))
+	require.Nil(t, err)
+
+	require.Equal(t, expected, b.String())
+}
+
+func TestPrinterDiff(t *testing.T) {
+	var b bytes.Buffer
+
+	p := NewPrinter().
+		WithComparisons([]resolver.Comparison{
+			{
+				Name:     "www.example.com",
+				Matching: []string{"10.0.0.1"},
+				Views: []resolver.View{
+					{Server: "10.0.0.53:53", Rcode: "NOERROR", Values: []string{"10.0.0.1"}},
+					{Server: "192.0.2.53:53", Rcode: "NOERROR", Values: []string{"10.0.0.1"}},
+				},
+			},
+			{
+				Name:     "api.example.com",
+				Matching: []string{"10.0.0.2"},
+				Views: []resolver.View{
+					{Server: "10.0.0.53:53", Rcode: "NOERROR", Values: []string{"10.0.0.2", "10.0.0.3"}, Missing: []string{"192.0.2.3"}},
+					{Server: "192.0.2.53:53", Rcode: "NOERROR", Values: []string{"10.0.0.2", "192.0.2.3"}, Missing: []string{"10.0.0.3"}},
+				},
+				Divergent: true,
+			},
+			{
+				Name:     "example.com",
+				Matching: []string{},
+				Views: []resolver.View{
+					{Server: "10.0.0.53:53", Rcode: "NOERROR", Values: []string{"10 mx1.example.com"}},
+					{Server: "192.0.2.53:53", Rcode: "SERVFAIL", Values: []string{}, Missing: []string{"10 mx1.example.com"}},
+				},
+				Divergent: true,
+			},
+		}).
+		WithOutput(&b).
+		WithFormat(FormatDiff)
+
+	err := p.Print()
+	require.Nil(t, err)
+
+	expected, err := getExpected(path.Join(expectedContentDirectory, "diff.txt"))
+	require.Nil(t, err)
+
+	require.Equal(t, expected, b.String())
+
+	b.Reset()
+	p.WithFormat(FormatJSON)
+	err = p.Print()
+	require.Nil(t, err)
+
+	expected, err = getExpected(path.Join(expectedContentDirectory, "json_diff.json"))
+	require.Nil(t, err)
+
+	require.Equal(t, expected, b.String())
+}
+
+func TestPrinterStream(t *testing.T) {
+	var b bytes.Buffer
+
+	stream := func(yield func(resolver.Response, error) bool) {
+		for _, response := range entries {
+			if !yield(response, nil) {
+				return
+			}
+		}
+	}
+
+	p := NewPrinter().
+		WithStream(stream).
+		WithOutput(&b).
+		WithFormat(FormatList)
+
+	err := p.Print()
+	require.Nil(t, err)
+	require.Equal(t, "8.8.8.8\n1.1.1.1\n10.10.10.10\n123.123.123.123\n8.8.8.8\n", b.String())
+
+	b.Reset()
+	p.WithFormat(FormatJSONL)
+	err = p.Print()
+	require.Nil(t, err)
+
+	expected, err := getExpected(path.Join(expectedContentDirectory, "jsonl.jsonl"))
+	require.Nil(t, err)
+	require.Equal(t, expected, b.String())
+
+	b.Reset()
+	p.WithEntries(nil).WithFormat(FormatJSON)
+	err = p.Print()
+	require.Nil(t, err)
+
+	expected, err = getExpected(path.Join(expectedContentDirectory, "json.json"))
+	require.Nil(t, err)
+	require.Equal(t, expected, b.String())
+
+	failed := func(yield func(resolver.Response, error) bool) {
+		if !yield(entries[0], nil) {
+			return
+		}
+		yield(resolver.Response{}, fmt.Errorf("no answer"))
+	}
+
+	b.Reset()
+	p.WithStream(failed).WithFormat(FormatHosts)
+	err = p.Print()
+	require.EqualError(t, err, "no answer")
+	require.Equal(t, "8.8.8.8 cloudflare.com\n1.1.1.1 cloudflare.com\n", b.String())
+}
+
+func uint32Ptr(i uint32) *uint32 {
+	return &i
+}
@@ -0,0 +1,187 @@
+package printer
+
+import (
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/pabateman/dns-lookuper/internal/resolver"
+)
+
+const (
+	varHost       = "host"
+	varFQDN       = "fqdn"
+	varAddress    = "address"
+	varValue      = "value"
+	varType       = "type"
+	varCNAMEs     = "cnames"
+	varPriority   = "priority"
+	varWeight     = "weight"
+	varPort       = "port"
+	varTarget     = "target"
+	varFlag       = "flag"
+	varTag        = "tag"
+	varMname      = "mname"
+	varRname      = "rname"
+	varSerial     = "serial"
+	varRefresh    = "refresh"
+	varRetry      = "retry"
+	varExpire     = "expire"
+	varMinttl     = "minttl"
+	varTTLMin     = "ttlMin"
+	varTTLMax     = "ttlMax"
+	varRcode      = "rcode"
+	varServer     = "server"
+	varSubnet     = "subnet"
+	varRTT        = "rtt"
+	varTime       = "timestamp"
+	varWildcard   = "wildcard"
+	varSamples    = "samples"
+	varSeen       = "seen"
+	varAD         = "ad"
+	varDNSSEC     = "dnssec"
+	varParams     = "params"
+	varProvenance = "provenance"
+)
+
+// templateVars returns variables of the template body, one set per record of
+// the response. Every set has the record value in both {{value}} and
+// {{address}}, so hosts, csv and list formats work for any record type.
+func templateVars(response resolver.Response) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0)
+
+	fqdn := response.FQDN
+	if fqdn == "" {
+		fqdn = response.Name
+	}
+
+	timestamp := ""
+	if response.Timestamp != nil {
+		timestamp = response.Timestamp.Format(time.RFC3339)
+	}
+
+	add := func(recordType string, value string) map[string]interface{} {
+		vars := map[string]interface{}{
+			varHost:     response.Name,
+			varFQDN:     fqdn,
+			varAddress:  value,
+			varValue:    value,
+			varType:     recordType,
+			varCNAMEs:   strings.Join(response.CNAMEs, ","),
+			varTTLMin:   ttl(response.TTLMin),
+			varTTLMax:   ttl(response.TTLMax),
+			varRcode:    response.Rcode,
+			varServer:   response.Server,
+			varSubnet:   response.Subnet,
+			varRTT:      response.RTT.String(),
+			varTime:     timestamp,
+			varWildcard: strconv.FormatBool(response.Wildcard),
+			varSamples:  strconv.Itoa(response.Samples),
+			varSeen:     strconv.Itoa(response.Appearances[value]),
+			varAD:       strconv.FormatBool(response.AD),
+			varDNSSEC:   response.DNSSEC,
+		}
+		result = append(result, vars)
+		return vars
+	}
+
+	for _, address := range response.Addresses {
+		recordType := dns.TypeToString[dns.TypeA]
+		if addr, err := netip.ParseAddr(address); err == nil && addr.Is6() {
+			recordType = dns.TypeToString[dns.TypeAAAA]
+		}
+		vars := add(recordType, address)
+		vars[varProvenance] = response.Provenance[address]
+	}
+
+	for _, mx := range response.MX {
+		vars := add(dns.TypeToString[dns.TypeMX], uitoa(mx.Priority)+" "+mx.Target)
+		vars[varPriority] = uitoa(mx.Priority)
+		vars[varTarget] = mx.Target
+	}
+
+	for _, srv := range response.SRV {
+		vars := add(dns.TypeToString[dns.TypeSRV], strings.Join([]string{uitoa(srv.Priority), uitoa(srv.Weight), uitoa(srv.Port), srv.Target}, " "))
+		vars[varPriority] = uitoa(srv.Priority)
+		vars[varWeight] = uitoa(srv.Weight)
+		vars[varPort] = uitoa(srv.Port)
+		vars[varTarget] = srv.Target
+	}
+
+	for _, txt := range response.TXT {
+		add(dns.TypeToString[dns.TypeTXT], txt)
+	}
+
+	for _, ns := range response.NS {
+		vars := add(dns.TypeToString[dns.TypeNS], ns)
+		vars[varTarget] = ns
+	}
+
+	for _, caa := range response.CAA {
+		vars := add(dns.TypeToString[dns.TypeCAA], strings.Join([]string{uitoa(caa.Flag), caa.Tag, caa.Value}, " "))
+		vars[varFlag] = uitoa(caa.Flag)
+		vars[varTag] = caa.Tag
+	}
+
+	if soa := response.SOA; soa != nil {
+		vars := add(dns.TypeToString[dns.TypeSOA], strings.Join([]string{
+			soa.Mname, soa.Rname, uitoa(soa.Serial), uitoa(soa.Refresh), uitoa(soa.Retry), uitoa(soa.Expire), uitoa(soa.Minttl),
+		}, " "))
+		vars[varMname] = soa.Mname
+		vars[varRname] = soa.Rname
+		vars[varSerial] = uitoa(soa.Serial)
+		vars[varRefresh] = uitoa(soa.Refresh)
+		vars[varRetry] = uitoa(soa.Retry)
+		vars[varExpire] = uitoa(soa.Expire)
+		vars[varMinttl] = uitoa(soa.Minttl)
+	}
+
+	// Reverse lookups map the address to names, so hosts and csv formats get
+	// the queried address in {{address}} and the name in {{host}}.
+	_, err := netip.ParseAddr(response.Name)
+	reverse := err == nil
+
+	for _, ptr := range response.PTR {
+		vars := add(dns.TypeToString[dns.TypePTR], ptr)
+		vars[varTarget] = ptr
+		if reverse {
+			vars[varHost] = ptr
+			vars[varAddress] = response.Name
+		}
+	}
+
+	// Every SvcParam is available under its key as well, e.g. {{alpn}}.
+	for _, svcb := range response.SVCB {
+		vars := add(svcb.Type, svcb.String())
+		vars[varSeen] = strconv.Itoa(response.Appearances[svcb.Type+" "+svcb.String()])
+		for key, value := range svcb.Params {
+			vars[key] = value
+		}
+		vars[varPriority] = uitoa(svcb.Priority)
+		vars[varTarget] = svcb.Target
+		vars[varParams] = svcb.ParamsString()
+	}
+
+	for _, record := range response.Records {
+		vars := add(record.Type, record.Value)
+		vars[varSeen] = strconv.Itoa(response.Appearances[record.Type+" "+record.Value])
+	}
+
+	return result
+}
+
+func uitoa[T uint8 | uint16 | uint32](i T) string {
+	return strconv.FormatUint(uint64(i), 10)
+}
+
+// ttl is empty for responses without records.
+func ttl(t *uint32) string {
+	if t == nil {
+		return ""
+	}
+
+	return uitoa(*t)
+}
@@ -0,0 +1,93 @@
+package resolver
+
+import (
+	"slices"
+)
+
+// Comparison lines up answers of several upstream servers for one name.
+type Comparison struct {
+	Name      string   `json:"name"`
+	Matching  []string `json:"matching"`
+	Views     []View   `json:"views"`
+	Divergent bool     `json:"divergent,omitempty"`
+}
+
+// View is the answer of one server; Missing holds values returned by other
+// servers but not by this one.
+type View struct {
+	Server  string   `json:"server"`
+	Rcode   string   `json:"rcode"`
+	Values  []string `json:"values"`
+	Missing []string `json:"missing,omitempty"`
+}
+
+// Compare takes responses of every server to the same list of names, in the
+// order of servers, and tells per name which values all servers agree on and
+// which ones some of them miss. A name is divergent when servers return
+// different values or different rcodes.
+func Compare(servers []string, responses [][]Response) []Comparison {
+	if len(responses) == 0 {
+		return make([]Comparison, 0)
+	}
+
+	result := make([]Comparison, len(responses[0]))
+
+	for i := range result {
+		views := make([]View, len(servers))
+		union := make([]string, 0)
+
+		for j, server := range servers {
+			views[j] = View{
+				Server: server,
+				Rcode:  responses[j][i].Rcode,
+				Values: responses[j][i].Values(),
+			}
+			union = append(union, views[j].Values...)
+		}
+
+		slices.Sort(union)
+		union = slices.Compact(union)
+
+		comparison := Comparison{
+			Name:     responses[0][i].Name,
+			Matching: make([]string, 0),
+		}
+
+		for _, value := range union {
+			matching := true
+			for j := range views {
+				if !slices.Contains(views[j].Values, value) {
+					views[j].Missing = append(views[j].Missing, value)
+					matching = false
+				}
+			}
+
+			if matching {
+				comparison.Matching = append(comparison.Matching, value)
+			}
+		}
+
+		for _, view := range views {
+			if len(view.Missing) > 0 || view.Rcode != views[0].Rcode {
+				comparison.Divergent = true
+			}
+		}
+
+		comparison.Views = views
+		result[i] = comparison
+	}
+
+	return result
+}
+
+func FilterComparisonsDivergent(cs []Comparison) []Comparison {
+	result := make([]Comparison, 0)
+
+	for _, c := range cs {
+		if c.Divergent {
+			result = append(result, c)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,70 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	internal := "10.0.0.53:53"
+	external := "192.0.2.53:53"
+	servers := []string{internal, external}
+
+	responses := [][]Response{
+		{
+			{Name: "www.example", Addresses: []string{"10.0.0.1"}, Rcode: "NOERROR"},
+			{Name: "api.example", Addresses: []string{"10.0.0.3", "10.0.0.2"}, Rcode: "NOERROR"},
+			{Name: "intranet.example", Addresses: []string{"10.0.0.4"}, Rcode: "NOERROR"},
+			{Name: "missing.example", Addresses: []string{}, Rcode: "NXDOMAIN"},
+		},
+		{
+			{Name: "www.example", Addresses: []string{"10.0.0.1"}, Rcode: "NOERROR"},
+			{Name: "api.example", Addresses: []string{"10.0.0.2", "192.0.2.3"}, Rcode: "NOERROR"},
+			{Name: "intranet.example", Addresses: []string{}, Rcode: "NXDOMAIN"},
+			{Name: "missing.example", Addresses: []string{}, Rcode: "NXDOMAIN"},
+		},
+	}
+
+	comparisons := Compare(servers, responses)
+
+	require.Equal(t, []Comparison{
+		{
+			Name:     "www.example",
+			Matching: []string{"10.0.0.1"},
+			Views: []View{
+				{Server: internal, Rcode: "NOERROR", Values: []string{"10.0.0.1"}},
+				{Server: external, Rcode: "NOERROR", Values: []string{"10.0.0.1"}},
+			},
+		},
+		{
+			Name:     "api.example",
+			Matching: []string{"10.0.0.2"},
+			Views: []View{
+				{Server: internal, Rcode: "NOERROR", Values: []string{"10.0.0.2", "10.0.0.3"}, Missing: []string{"192.0.2.3"}},
+				{Server: external, Rcode: "NOERROR", Values: []string{"10.0.0.2", "192.0.2.3"}, Missing: []string{"10.0.0.3"}},
+			},
+			Divergent: true,
+		},
+		{
+			Name:     "intranet.example",
+			Matching: []string{},
+			Views: []View{
+				{Server: internal, Rcode: "NOERROR", Values: []string{"10.0.0.4"}},
+				{Server: external, Rcode: "NXDOMAIN", Values: []string{}, Missing: []string{"10.0.0.4"}},
+			},
+			Divergent: true,
+		},
+		{
+			Name:     "missing.example",
+			Matching: []string{},
+			Views: []View{
+				{Server: internal, Rcode: "NXDOMAIN", Values: []string{}},
+				{Server: external, Rcode: "NXDOMAIN", Values: []string{}},
+			},
+		},
+	}, comparisons)
+
+	require.Equal(t, comparisons[1:3], FilterComparisonsDivergent(comparisons))
+	require.Empty(t, Compare(nil, nil))
+}
@@ -0,0 +1,109 @@
+package resolver
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+type MX struct {
+	Priority uint16 `json:"priority"`
+	Target   string `json:"target"`
+}
+
+type SRV struct {
+	Priority uint16 `json:"priority"`
+	Weight   uint16 `json:"weight"`
+	Port     uint16 `json:"port"`
+	Target   string `json:"target"`
+}
+
+type CAA struct {
+	Flag  uint8  `json:"flag"`
+	Tag   string `json:"tag"`
+	Value string `json:"value"`
+}
+
+type SOA struct {
+	Mname   string `json:"mname"`
+	Rname   string `json:"rname"`
+	Serial  uint32 `json:"serial"`
+	Refresh uint32 `json:"refresh"`
+	Retry   uint32 `json:"retry"`
+	Expire  uint32 `json:"expire"`
+	Minttl  uint32 `json:"minttl"`
+}
+
+// SVCB is a record of type SVCB or HTTPS; Params holds SvcParams by their
+// keys in presentation format, e.g. "alpn": "h2,h3".
+type SVCB struct {
+	Type     string            `json:"type"`
+	Priority uint16            `json:"priority"`
+	Target   string            `json:"target"`
+	Params   map[string]string `json:"params,omitempty"`
+}
+
+// ParamsString returns SvcParams as they are written in zone files, sorted by
+// their keys.
+func (s SVCB) ParamsString() string {
+	params := make([]string, 0, len(s.Params))
+	for _, key := range slices.Sorted(maps.Keys(s.Params)) {
+		if value := s.Params[key]; value != "" {
+			params = append(params, key+"="+value)
+		} else {
+			params = append(params, key)
+		}
+	}
+
+	return strings.Join(params, " ")
+}
+
+// String returns rdata of the record in presentation format.
+func (s SVCB) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%d %s %s", s.Priority, s.Target, s.ParamsString()))
+}
+
+// Record keeps rdata in presentation format for types with no structure of
+// their own.
+type Record struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Values returns data of all records of the response in presentation format,
+// sorted and without duplicates, so responses can be compared as sets.
+func (r *Response) Values() []string {
+	result := append(make([]string, 0), r.Addresses...)
+
+	for _, mx := range r.MX {
+		result = append(result, fmt.Sprintf("%d %s", mx.Priority, mx.Target))
+	}
+
+	for _, srv := range r.SRV {
+		result = append(result, fmt.Sprintf("%d %d %d %s", srv.Priority, srv.Weight, srv.Port, srv.Target))
+	}
+
+	for _, caa := range r.CAA {
+		result = append(result, fmt.Sprintf("%d %s %s", caa.Flag, caa.Tag, caa.Value))
+	}
+
+	if soa := r.SOA; soa != nil {
+		result = append(result, fmt.Sprintf("%s %s %d %d %d %d %d", soa.Mname, soa.Rname, soa.Serial, soa.Refresh, soa.Retry, soa.Expire, soa.Minttl))
+	}
+
+	result = append(result, r.TXT...)
+	result = append(result, r.NS...)
+	result = append(result, r.PTR...)
+
+	for _, svcb := range r.SVCB {
+		result = append(result, svcb.Type+" "+svcb.String())
+	}
+
+	for _, record := range r.Records {
+		result = append(result, record.Type+" "+record.Value)
+	}
+
+	slices.Sort(result)
+	return slices.Compact(result)
+}
@@ -0,0 +1,37 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValues(t *testing.T) {
+	r := Response{
+		Addresses: []string{"10.0.0.2", "10.0.0.1", "10.0.0.2"},
+		MX:        []MX{{Priority: 10, Target: "mx.example"}},
+		TXT:       []string{"v=spf1 -all"},
+		Records:   []Record{{Type: "HINFO", Value: "\"cpu\" \"os\""}},
+		SVCB:      []SVCB{{Type: "HTTPS", Priority: 1, Target: ".", Params: map[string]string{"alpn": "h2"}}},
+	}
+
+	require.Equal(t, []string{"10 mx.example", "10.0.0.1", "10.0.0.2", "HINFO \"cpu\" \"os\"", "HTTPS 1 . alpn=h2", "v=spf1 -all"}, r.Values())
+	require.Equal(t, []string{}, (&Response{}).Values())
+}
+
+func TestSVCB(t *testing.T) {
+	svcb := SVCB{
+		Type:     "HTTPS",
+		Priority: 1,
+		Target:   "svc.example",
+		Params: map[string]string{
+			"port":            "8443",
+			"alpn":            "h3,h2",
+			"no-default-alpn": "",
+		},
+	}
+
+	require.Equal(t, "alpn=h3,h2 no-default-alpn port=8443", svcb.ParamsString())
+	require.Equal(t, "1 svc.example alpn=h3,h2 no-default-alpn port=8443", svcb.String())
+	require.Equal(t, "0 pool.example", SVCB{Type: "HTTPS", Target: "pool.example"}.String())
+}
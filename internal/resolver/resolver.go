@@ -0,0 +1,132 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	BackendSystem  = "system"
+	BackendDNS     = "dns"
+	BackendDefault = BackendDNS
+)
+
+// Resolver is implemented by backends: the system one asks the resolver of
+// the OS, the dns one talks to DNS servers itself. Responses keep the order
+// of names; Resolve returns the error of ctx once it is done. ResolveStream
+// takes names as they are read and yields responses as they are ready,
+// stopping after the first error, so memory does not grow with the number of
+// names.
+type Resolver interface {
+	Resolve(ctx context.Context, dn []string) ([]Response, error)
+	ResolveStream(ctx context.Context, dn iter.Seq[string]) iter.Seq2[Response, error]
+}
+
+// Collect gathers responses of the stream; it returns the first error of the
+// stream instead of them.
+func Collect(seq iter.Seq2[Response, error]) ([]Response, error) {
+	result := make([]Response, 0)
+
+	for response, err := range seq {
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, response)
+	}
+
+	return result, nil
+}
+
+type Response struct {
+	Name        string            `json:"name"`
+	FQDN        string            `json:"fqdn,omitempty"`
+	Addresses   []string          `json:"addresses"`
+	Provenance  map[string]string `json:"provenance,omitempty"`
+	CNAMEs      []string          `json:"cnames,omitempty"`
+	MX          []MX              `json:"mx,omitempty"`
+	SRV         []SRV             `json:"srv,omitempty"`
+	TXT         []string          `json:"txt,omitempty"`
+	NS          []string          `json:"ns,omitempty"`
+	CAA         []CAA             `json:"caa,omitempty"`
+	SOA         *SOA              `json:"soa,omitempty"`
+	PTR         []string          `json:"ptr,omitempty"`
+	SVCB        []SVCB            `json:"svcb,omitempty"`
+	Records     []Record          `json:"records,omitempty"`
+	Rcode       string            `json:"rcode,omitempty"`
+	TTLMin      *uint32           `json:"ttlMin,omitempty"`
+	TTLMax      *uint32           `json:"ttlMax,omitempty"`
+	Server      string            `json:"server,omitempty"`
+	Subnet      string            `json:"subnet,omitempty"`
+	RTT         Duration          `json:"rtt,omitempty"`
+	Timestamp   *time.Time        `json:"timestamp,omitempty"`
+	Cached      bool              `json:"cached,omitempty"`
+	AD          bool              `json:"ad,omitempty"`
+	DNSSEC      string            `json:"dnssec,omitempty"`
+	Wildcard    bool              `json:"wildcard,omitempty"`
+	Samples     int               `json:"samples,omitempty"`
+	Appearances map[string]int    `json:"appearances,omitempty"`
+	Partial     bool              `json:"partial,omitempty"`
+	Errors      []string          `json:"errors,omitempty"`
+}
+
+// Duration is marshalled in the form of time.Duration.String, e.g. "1.5ms".
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+func FilterResponsesByRcode(rs []Response, rcode int) []Response {
+	result := make([]Response, 0)
+
+	for _, r := range rs {
+		if r.Rcode == dns.RcodeToString[rcode] {
+			result = append(result, r)
+		}
+	}
+
+	return result
+}
+
+func FilterResponsesNoerror(rs []Response) []Response {
+	return FilterResponsesByRcode(rs, dns.StringToRcode["NOERROR"])
+}
+
+func FilterResponsesNxdomain(rs []Response) []Response {
+	return FilterResponsesByRcode(rs, dns.StringToRcode["NXDOMAIN"])
+}
+
+func FilterResponsesPartial(rs []Response) []Response {
+	result := make([]Response, 0)
+
+	for _, r := range rs {
+		if r.Partial {
+			result = append(result, r)
+		}
+	}
+
+	return result
+}
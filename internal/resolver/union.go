@@ -0,0 +1,115 @@
+package resolver
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Union merges responses to the same name, e.g. answers for several client
+// subnets, into one response per name in the order names first appear.
+// Records of all responses are kept without duplicates, Subnet lists subnets
+// of all of them separated by commas.
+func Union(responses []Response) []Response {
+	result := make([]Response, 0)
+	index := make(map[string]int)
+
+	for _, response := range responses {
+		i, ok := index[response.Name]
+		if !ok {
+			index[response.Name] = len(result)
+			result = append(result, response)
+			continue
+		}
+
+		merged := &result[i]
+		merged.Addresses = appendUnique(merged.Addresses, response.Addresses)
+		merged.CNAMEs = appendUnique(merged.CNAMEs, response.CNAMEs)
+		merged.MX = appendUnique(merged.MX, response.MX)
+		merged.SRV = appendUnique(merged.SRV, response.SRV)
+		merged.TXT = appendUnique(merged.TXT, response.TXT)
+		merged.NS = appendUnique(merged.NS, response.NS)
+		merged.CAA = appendUnique(merged.CAA, response.CAA)
+		merged.PTR = appendUnique(merged.PTR, response.PTR)
+		merged.SVCB = appendUniqueFunc(merged.SVCB, response.SVCB, SVCB.String)
+		merged.Records = appendUnique(merged.Records, response.Records)
+		merged.Errors = appendUnique(merged.Errors, response.Errors)
+
+		if merged.SOA == nil {
+			merged.SOA = response.SOA
+		}
+
+		switch {
+		case merged.Subnet == "":
+			merged.Subnet = response.Subnet
+		case response.Subnet != "":
+			merged.Subnet = strings.Join(appendUnique(strings.Split(merged.Subnet, ","), []string{response.Subnet}), ",")
+		}
+
+		if response.TTLMin != nil && (merged.TTLMin == nil || *response.TTLMin < *merged.TTLMin) {
+			merged.TTLMin = response.TTLMin
+		}
+
+		if response.TTLMax != nil && (merged.TTLMax == nil || *response.TTLMax > *merged.TTLMax) {
+			merged.TTLMax = response.TTLMax
+		}
+
+		merged.RTT = max(merged.RTT, response.RTT)
+		merged.Cached = merged.Cached && response.Cached
+		merged.AD = merged.AD && response.AD
+		merged.Partial = merged.Partial || response.Partial
+		merged.Wildcard = merged.Wildcard || response.Wildcard
+		merged.Samples += response.Samples
+
+		if response.Provenance != nil {
+			merged.Provenance = maps.Clone(merged.Provenance)
+			if merged.Provenance == nil {
+				merged.Provenance = make(map[string]string)
+			}
+
+			for address, source := range response.Provenance {
+				if _, ok := merged.Provenance[address]; !ok {
+					merged.Provenance[address] = source
+				}
+			}
+		}
+
+		if response.Appearances != nil {
+			merged.Appearances = maps.Clone(merged.Appearances)
+			if merged.Appearances == nil {
+				merged.Appearances = make(map[string]int)
+			}
+
+			for value, count := range response.Appearances {
+				merged.Appearances[value] += count
+			}
+		}
+	}
+
+	return result
+}
+
+// appendUnique returns dst with values of src it does not have yet; dst is
+// copied before it grows, so slices of merged responses are not modified.
+func appendUnique[T comparable](dst []T, src []T) []T {
+	dst = slices.Clip(dst)
+	for _, value := range src {
+		if !slices.Contains(dst, value) {
+			dst = append(dst, value)
+		}
+	}
+
+	return dst
+}
+
+// appendUniqueFunc is appendUnique for values told apart by their keys.
+func appendUniqueFunc[T any](dst []T, src []T, key func(T) string) []T {
+	dst = slices.Clip(dst)
+	for _, value := range src {
+		if !slices.ContainsFunc(dst, func(v T) bool { return key(v) == key(value) }) {
+			dst = append(dst, value)
+		}
+	}
+
+	return dst
+}
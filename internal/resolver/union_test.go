@@ -0,0 +1,80 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnion(t *testing.T) {
+	responses := []Response{
+		{Name: "cdn.example", Addresses: []string{"192.0.2.1", "192.0.2.2"}, Rcode: "NOERROR", Subnet: "198.51.100.0/24", TTLMin: uint32Ptr(60), TTLMax: uint32Ptr(60), AD: true},
+		{Name: "www.example", Addresses: []string{"192.0.2.10"}, Rcode: "NOERROR", Subnet: "198.51.100.0/24", TTLMin: uint32Ptr(300), TTLMax: uint32Ptr(300), AD: true},
+		{Name: "cdn.example", Addresses: []string{"203.0.113.1", "192.0.2.2"}, Rcode: "NOERROR", Subnet: "203.0.113.0/24", TTLMin: uint32Ptr(30), TTLMax: uint32Ptr(30)},
+		{Name: "www.example", Addresses: []string{"192.0.2.10"}, Rcode: "NOERROR", Subnet: "203.0.113.0/24", TTLMin: uint32Ptr(300), TTLMax: uint32Ptr(300), AD: true},
+	}
+
+	require.Equal(t, []Response{
+		{Name: "cdn.example", Addresses: []string{"192.0.2.1", "192.0.2.2", "203.0.113.1"}, Rcode: "NOERROR", Subnet: "198.51.100.0/24,203.0.113.0/24", TTLMin: uint32Ptr(30), TTLMax: uint32Ptr(60)},
+		{Name: "www.example", Addresses: []string{"192.0.2.10"}, Rcode: "NOERROR", Subnet: "198.51.100.0/24,203.0.113.0/24", TTLMin: uint32Ptr(300), TTLMax: uint32Ptr(300), AD: true},
+	}, Union(responses))
+
+	require.Equal(t, []string{"192.0.2.1", "192.0.2.2"}, responses[0].Addresses)
+}
+
+func TestUnionTTL(t *testing.T) {
+	responses := []Response{
+		{Name: "www.example", Addresses: []string{}, Rcode: "NOERROR"},
+		{Name: "www.example", Addresses: []string{"192.0.2.1"}, Rcode: "NOERROR", TTLMin: uint32Ptr(0), TTLMax: uint32Ptr(0)},
+		{Name: "www.example", Addresses: []string{"192.0.2.2"}, Rcode: "NOERROR", TTLMin: uint32Ptr(60), TTLMax: uint32Ptr(60)},
+	}
+
+	union := Union(responses)
+	require.Equal(t, uint32Ptr(0), union[0].TTLMin)
+	require.Equal(t, uint32Ptr(60), union[0].TTLMax)
+
+	union = Union([]Response{responses[0], responses[2]})
+	require.Equal(t, uint32Ptr(60), union[0].TTLMin)
+}
+
+func TestUnionSamples(t *testing.T) {
+	responses := []Response{
+		{Name: "lb.example", Addresses: []string{"192.0.2.1", "192.0.2.2"}, Rcode: "NOERROR", Samples: 2, Appearances: map[string]int{"192.0.2.1": 2, "192.0.2.2": 1}},
+		{Name: "lb.example", Addresses: []string{"192.0.2.2"}, Rcode: "NOERROR", Samples: 2, Appearances: map[string]int{"192.0.2.2": 2}},
+	}
+
+	union := Union(responses)
+	require.Equal(t, 4, union[0].Samples)
+	require.Equal(t, map[string]int{"192.0.2.1": 2, "192.0.2.2": 3}, union[0].Appearances)
+	require.Equal(t, map[string]int{"192.0.2.1": 2, "192.0.2.2": 1}, responses[0].Appearances)
+}
+
+func TestUnionSVCB(t *testing.T) {
+	responses := []Response{
+		{
+			Name:       "svc.example",
+			Addresses:  []string{"192.0.2.1"},
+			Provenance: map[string]string{"192.0.2.1": "HTTPS svc.example ipv4hint"},
+			SVCB:       []SVCB{{Type: "HTTPS", Priority: 1, Target: ".", Params: map[string]string{"ipv4hint": "192.0.2.1"}}},
+		},
+		{
+			Name:       "svc.example",
+			Addresses:  []string{"192.0.2.2"},
+			Provenance: map[string]string{"192.0.2.2": "HTTPS svc.example ipv4hint"},
+			SVCB: []SVCB{
+				{Type: "HTTPS", Priority: 1, Target: ".", Params: map[string]string{"ipv4hint": "192.0.2.1"}},
+				{Type: "HTTPS", Priority: 2, Target: ".", Params: map[string]string{"ipv4hint": "192.0.2.2"}},
+			},
+		},
+	}
+
+	union := Union(responses)
+	require.Equal(t, []string{"192.0.2.1", "192.0.2.2"}, union[0].Addresses)
+	require.Len(t, union[0].SVCB, 2)
+	require.Len(t, union[0].Provenance, 2)
+	require.Len(t, responses[0].Provenance, 1)
+}
+
+func uint32Ptr(i uint32) *uint32 {
+	return &i
+}
@@ -2,8 +2,14 @@ package resolver
 
 import (
 	"context"
+	"iter"
 	"net"
+	"slices"
 	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/pabateman/dns-lookuper/internal/resolver"
 )
 
 const (
@@ -17,11 +23,7 @@ const (
 	TimeoutDefault = time.Duration(15 * time.Second)
 )
 
-type Response struct {
-	Name      string   `json:"name"`
-	Addresses []net.IP `json:"addresses"`
-	Error     error    `json:"-"`
-}
+type Response = resolver.Response
 
 type Resolver struct {
 	net.Resolver
@@ -47,46 +49,77 @@ func (r *Resolver) WithMode(m string) *Resolver {
 	return r
 }
 
-func (r *Resolver) Resolve(dn []string) ([]Response, error) {
-	responses := make([]Response, 0)
+// Resolve looks names up with the resolver of the system, so /etc/hosts,
+// nsswitch and the like take part. Names not found get NXDOMAIN and timed out
+// lookups get SERVFAIL, as the system resolver tells nothing more of rcodes.
+// The timeout applies to every name, ctx to the whole call.
+func (r *Resolver) Resolve(ctx context.Context, dn []string) ([]Response, error) {
+	return resolver.Collect(r.ResolveStream(ctx, slices.Values(dn)))
+}
 
-	for _, name := range dn {
-		response := Response{
-			Name:      name,
-			Addresses: make([]net.IP, 0),
-			Error:     nil,
-		}
+// ResolveStream looks names up one by one and yields their responses.
+func (r *Resolver) ResolveStream(ctx context.Context, dn iter.Seq[string]) iter.Seq2[Response, error] {
+	return func(yield func(Response, error) bool) {
+		for name := range dn {
+			response, err := r.resolveName(ctx, name)
+			if err != nil {
+				yield(Response{}, err)
+				return
+			}
 
-		ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
-		defer cancel()
-
-		answer, err := r.LookupIP(ctx, r.mode, name)
-		if err != nil {
-			if dnsError, ok := err.(*net.DNSError); ok {
-				if dnsError.IsNotFound || dnsError.IsTimeout {
-					response.Error = err
-					responses = append(responses, response)
-					continue
-				} else {
-					return nil, err
-				}
-			} else if addrError, ok := err.(*net.AddrError); ok {
-				if addrError.Err != "no suitable address found" {
-					return nil, err
-				}
-			} else {
-				return nil, err
+			if !yield(response, nil) {
+				return
 			}
 		}
+	}
+}
+
+func (r *Resolver) resolveName(ctx context.Context, name string) (Response, error) {
+	timestamp := time.Now()
+	response := Response{
+		Name:      name,
+		Addresses: make([]string, 0),
+		Rcode:     dns.RcodeToString[dns.RcodeSuccess],
+		Timestamp: &timestamp,
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	answer, err := r.LookupIP(lookupCtx, r.mode, name)
+	response.RTT = resolver.Duration(time.Since(timestamp))
+
+	if ctx.Err() != nil {
+		return Response{}, ctx.Err()
+	}
 
-		if answer != nil {
-			response.Addresses = answer
+	if err != nil {
+		if dnsError, ok := err.(*net.DNSError); ok {
+			switch {
+			case dnsError.IsNotFound:
+				response.Rcode = dns.RcodeToString[dns.RcodeNameError]
+				return response, nil
+			case dnsError.IsTimeout:
+				response.Rcode = dns.RcodeToString[dns.RcodeServerFailure]
+				response.Errors = append(response.Errors, err.Error())
+				return response, nil
+			default:
+				return Response{}, err
+			}
+		} else if addrError, ok := err.(*net.AddrError); ok {
+			if addrError.Err != "no suitable address found" {
+				return Response{}, err
+			}
+		} else {
+			return Response{}, err
 		}
+	}
 
-		responses = append(responses, response)
-		cancel()
+	for _, ip := range answer {
+		response.Addresses = append(response.Addresses, ip.String())
 	}
-	return responses, nil
+
+	return response, nil
 }
 
 func getIPMode(m string) string {
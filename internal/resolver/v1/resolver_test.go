@@ -1,6 +1,7 @@
 package resolver
 
 import (
+	"context"
 	"net"
 	"slices"
 	"testing"
@@ -28,19 +29,19 @@ var (
 	expectedValid = []Response{
 		{
 			Name: "iana.org",
-			Addresses: []net.IP{
-				{192, 0, 43, 8},
-				{32, 1, 5, 0, 0, 136, 2, 0, 0, 0, 0, 0, 0, 0, 0, 8},
+			Addresses: []string{
+				"192.0.43.8",
+				"2001:500:88:200::8",
 			},
-			Error: nil,
+			Rcode: "NOERROR",
 		},
 		{
 			Name: "kernel.org",
-			Addresses: []net.IP{
-				{139, 178, 84, 217},
-				{38, 4, 19, 128, 70, 65, 197, 0, 0, 0, 0, 0, 0, 0, 0, 1},
+			Addresses: []string{
+				"139.178.84.217",
+				"2604:1380:4641:c500::1",
 			},
-			Error: nil,
+			Rcode: "NOERROR",
 		},
 	}
 
@@ -86,16 +87,16 @@ var (
 func deepCopyResponses(r []Response) []Response {
 	result := make([]Response, len(r))
 	for i := range r {
-		result[i].Error = r[i].Error
+		result[i].Rcode = r[i].Rcode
 		result[i].Name = r[i].Name
-		result[i].Addresses = make([]net.IP, len(r[i].Addresses))
+		result[i].Addresses = make([]string, len(r[i].Addresses))
 		copy(result[i].Addresses, r[i].Addresses)
 	}
 
 	return result
 }
 
-func filterResponses(r []Response, f func(net.IP) bool) []Response {
+func filterResponses(r []Response, f func(string) bool) []Response {
 	for i := range r {
 		for {
 			index := slices.IndexFunc(r[i].Addresses, f)
@@ -111,15 +112,25 @@ func filterResponses(r []Response, f func(net.IP) bool) []Response {
 	return r
 }
 
-func notIPv4(ip net.IP) bool { return len(ip) != 4 }
-func notIPv6(ip net.IP) bool { return len(ip) != 16 }
+func notIPv4(ip string) bool { return net.ParseIP(ip).To4() == nil }
+func notIPv6(ip string) bool { return net.ParseIP(ip).To4() != nil }
+
+// stripTiming drops fields which differ from run to run.
+func stripTiming(r []Response) []Response {
+	for i := range r {
+		r[i].RTT = 0
+		r[i].Timestamp = nil
+	}
+
+	return r
+}
 
 func TestBasicResolver(t *testing.T) {
 	r := NewResolver()
-	responsesValid, err := r.Resolve(dnValid)
+	responsesValid, err := r.Resolve(context.Background(), dnValid)
 	require.Nil(t, err)
 
-	require.Equal(t, expectedValid, responsesValid)
+	require.Equal(t, expectedValid, stripTiming(responsesValid))
 }
 
 func TestMode(t *testing.T) {
@@ -142,44 +153,44 @@ func TestMode(t *testing.T) {
 	expectedIPv4 = filterResponses(expectedIPv4, notIPv4)
 
 	r := NewResolver().WithMode(ModeIpv4)
-	responses, err := r.Resolve(dnValid)
+	responses, err := r.Resolve(context.Background(), dnValid)
 	require.Nil(t, err)
 
-	require.Equal(t, expectedIPv4, responses)
+	require.Equal(t, expectedIPv4, stripTiming(responses))
 
 	expectedIPv6 := deepCopyResponses(expectedValid)
 	expectedIPv6 = filterResponses(expectedIPv6, notIPv6)
 
 	r.WithMode(ModeIpv6)
-	responses, err = r.Resolve(dnValid)
+	responses, err = r.Resolve(context.Background(), dnValid)
 	require.Nil(t, err)
 
-	require.Equal(t, expectedIPv6, responses)
+	require.Equal(t, expectedIPv6, stripTiming(responses))
 
 }
 
 // func TestErrorResponses(t *testing.T) {
 // 	r := NewResolver().WithTimeout(time.Second * 5)
 
-// 	responses, err := r.Resolve(dnOnlyIPv4)
+// 	responses, err := r.Resolve(context.Background(), dnOnlyIPv4)
 // 	require.Nil(t, err)
 
 // 	require.Equal(t, responses, expectedOnlyIPv4)
 
 // 	r.WithMode(ModeIpv6)
-// 	responses, err = r.Resolve(dnOnlyIPv4)
+// 	responses, err = r.Resolve(context.Background(), dnOnlyIPv4)
 // 	require.Nil(t, err)
 
 // 	expectedIPv6 := deepCopyResponses(expectedOnlyIPv4)
 // 	expectedIPv6 = filterResponses(expectedIPv6, notIPv6)
 
-// 	require.Equal(t, expectedIPv6, responses)
+// 	require.Equal(t, expectedIPv6, stripTiming(responses))
 // }
 
 // func TestInvalidDN(t *testing.T) {
 // 	r := NewResolver().WithTimeout(time.Second * 5)
 
-// 	responses, err := r.Resolve(dnNonExistent)
+// 	responses, err := r.Resolve(context.Background(), dnNonExistent)
 // 	require.Nil(t, err)
 
 // 	require.Equal(t, expectedNonExistend, responses)
@@ -188,17 +199,33 @@ func TestMode(t *testing.T) {
 func TestTimeout(t *testing.T) {
 	r := NewResolver().WithTimeout(time.Microsecond * 10)
 
-	response, err := r.Resolve([]string{dnValid[0]})
+	response, err := r.Resolve(context.Background(), []string{dnValid[0]})
 	require.Nil(t, err)
 
-	dnsErr, ok := response[0].Error.(*net.DNSError)
+	require.Equal(t, "SERVFAIL", response[0].Rcode)
+	require.Len(t, response[0].Errors, 1)
+	require.Contains(t, response[0].Errors[0], "i/o timeout")
+}
+
+func TestHosts(t *testing.T) {
+	r := NewResolver().WithMode(ModeIpv4)
+
+	responses, err := r.Resolve(context.Background(), []string{"localhost"})
+	require.Nil(t, err)
+
+	require.Equal(t, []Response{
+		{
+			Name:      "localhost",
+			Addresses: []string{"127.0.0.1"},
+			Rcode:     "NOERROR",
+		},
+	}, stripTiming(responses))
+}
 
-	require.True(t, ok)
-	require.NotNil(t, dnsErr)
+func TestCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
-	require.True(t, dnsErr.IsTemporary)
-	require.True(t, dnsErr.IsTimeout)
-	require.False(t, dnsErr.IsNotFound)
-	require.Equal(t, dnsErr.Name, "iana.org")
-	require.Equal(t, dnsErr.Err, "i/o timeout")
+	_, err := NewResolver().Resolve(ctx, []string{"localhost"})
+	require.ErrorIs(t, err, context.Canceled)
 }
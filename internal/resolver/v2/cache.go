@@ -0,0 +1,205 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	CacheMinTTLDefault = time.Duration(0)
+	CacheMaxTTLDefault = time.Duration(time.Hour)
+)
+
+// Cache keeps responses of upstream servers for the TTL of their answers, so
+// resolvers sharing it do not repeat queries while records are fresh. Negative
+// answers are kept for the SOA minimum of the authority section as RFC 2308
+// suggests; responses with other rcodes are never cached.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[cacheKey]cacheEntry
+	minTTL  time.Duration
+	maxTTL  time.Duration
+	now     func() time.Time
+}
+
+type cacheKey struct {
+	name   string
+	qtype  uint16
+	server string
+	subnet string
+	dnssec bool
+}
+
+type cacheEntry struct {
+	msg       *dns.Msg
+	timestamp time.Time
+	stored    time.Time
+	expires   time.Time
+}
+
+type bypassCache struct{}
+
+// BypassCache returns a context under which resolvers query servers instead
+// of answering from the cache; fresh responses are still stored in it.
+func BypassCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassCache{}, true)
+}
+
+func bypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(bypassCache{}).(bool)
+	return bypass
+}
+
+func NewCache() *Cache {
+	return &Cache{
+		entries: make(map[cacheKey]cacheEntry),
+		minTTL:  CacheMinTTLDefault,
+		maxTTL:  CacheMaxTTLDefault,
+		now:     time.Now,
+	}
+}
+
+// WithMinTTL keeps responses at least for the given duration even when the
+// TTL of the answer is lower.
+func (c *Cache) WithMinTTL(t time.Duration) *Cache {
+	c.minTTL = max(t, 0)
+	return c
+}
+
+// WithMaxTTL caps the time responses are kept; zero means no cap.
+func (c *Cache) WithMaxTTL(t time.Duration) *Cache {
+	c.maxTTL = max(t, 0)
+	return c
+}
+
+// Len returns the number of entries including expired ones not pruned yet.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// Prune drops expired entries.
+func (c *Cache) Prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// get returns a copy of the cached response to the query with TTLs reduced
+// by the time it spent in the cache.
+func (c *Cache) get(msg *dns.Msg, server string) (*reply, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := newCacheKey(msg, server)
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	now := c.now()
+	if !now.Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	elapsed := uint32(now.Sub(entry.stored) / time.Second)
+
+	response := entry.msg.Copy()
+	response.Id = msg.Id
+	for _, section := range [][]dns.RR{response.Answer, response.Ns, response.Extra} {
+		for _, rr := range section {
+			header := rr.Header()
+			if header.Rrtype == dns.TypeOPT {
+				continue
+			}
+			header.Ttl -= min(header.Ttl, elapsed)
+		}
+	}
+
+	return &reply{
+		msg:       response,
+		server:    server,
+		timestamp: entry.timestamp,
+		cached:    true,
+	}, true
+}
+
+func (c *Cache) set(msg *dns.Msg, reply *reply) {
+	ttl, ok := cacheTTL(reply.msg)
+	if !ok {
+		return
+	}
+
+	ttl = max(ttl, c.minTTL)
+	if c.maxTTL > 0 {
+		ttl = min(ttl, c.maxTTL)
+	}
+
+	if ttl == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.entries[newCacheKey(msg, reply.server)] = cacheEntry{
+		msg:       reply.msg.Copy(),
+		timestamp: reply.timestamp,
+		stored:    now,
+		expires:   now.Add(ttl),
+	}
+}
+
+func newCacheKey(msg *dns.Msg, server string) cacheKey {
+	return cacheKey{
+		name:   dns.CanonicalName(msg.Question[0].Name),
+		qtype:  msg.Question[0].Qtype,
+		server: server,
+		subnet: clientSubnet(msg),
+		dnssec: msg.IsEdns0() != nil && msg.IsEdns0().Do(),
+	}
+}
+
+// cacheTTL returns the lowest TTL of the answer for positive responses and
+// the SOA minimum for NXDOMAIN and NODATA ones.
+func cacheTTL(msg *dns.Msg) (time.Duration, bool) {
+	var ttl uint32
+	found := false
+
+	lower := func(t uint32) {
+		if !found || t < ttl {
+			ttl = t
+		}
+		found = true
+	}
+
+	switch {
+	case msg.Truncated:
+		return 0, false
+	case msg.Rcode == dns.RcodeSuccess && len(msg.Answer) > 0:
+		for _, rr := range msg.Answer {
+			lower(rr.Header().Ttl)
+		}
+	case msg.Rcode == dns.RcodeSuccess || msg.Rcode == dns.RcodeNameError:
+		for _, rr := range msg.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				lower(min(soa.Hdr.Ttl, soa.Minttl))
+			}
+		}
+	}
+
+	return time.Duration(ttl) * time.Second, found
+}
@@ -0,0 +1,167 @@
+package resolver
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	zone := zoneHandler(t,
+		"www.example. 60 IN A 10.0.0.1",
+		"flaky.example. 60 IN A 10.0.0.2",
+	)
+
+	var queries atomic.Int32
+	server := startServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		queries.Add(1)
+
+		msg := new(dns.Msg)
+		switch req.Question[0].Name {
+		case "missing.example.":
+			msg.SetRcode(req, dns.RcodeNameError)
+			soa, err := dns.NewRR("example. 300 IN SOA ns1.example. hostmaster.example. 1 7200 3600 1209600 30")
+			require.Nil(t, err)
+			msg.Ns = append(msg.Ns, soa)
+		case "broken.example.":
+			msg.SetRcode(req, dns.RcodeServerFailure)
+		default:
+			zone(w, req)
+			return
+		}
+		_ = w.WriteMsg(msg)
+	}))
+
+	now := time.Now()
+	cache := NewCache()
+	cache.now = func() time.Time { return now }
+
+	r := NewResolver().
+		WithServers([]string{server}).
+		WithAttempts(1).
+		WithCache(cache)
+
+	names := []string{"www.example", "missing.example", "broken.example"}
+
+	response, err := r.Resolve(context.Background(), names)
+	require.Nil(t, err)
+	require.Equal(t, int32(3), queries.Load())
+	require.False(t, response[0].Cached)
+	require.Equal(t, 2, cache.Len())
+
+	now = now.Add(20 * time.Second)
+
+	response, err = r.Resolve(context.Background(), names)
+	require.Nil(t, err)
+	require.Equal(t, int32(4), queries.Load())
+
+	require.True(t, response[0].Cached)
+	require.Equal(t, []string{"10.0.0.1"}, response[0].Addresses)
+	require.Equal(t, uint32Ptr(40), response[0].TTLMin)
+	require.Equal(t, Duration(0), response[0].RTT)
+
+	require.True(t, response[1].Cached)
+	require.Equal(t, "NXDOMAIN", response[1].Rcode)
+
+	require.False(t, response[2].Cached)
+	require.Equal(t, "SERVFAIL", response[2].Rcode)
+
+	now = now.Add(20 * time.Second)
+
+	response, err = r.Resolve(context.Background(), names)
+	require.Nil(t, err)
+	require.Equal(t, int32(6), queries.Load())
+	require.True(t, response[0].Cached)
+	require.False(t, response[1].Cached)
+
+	now = now.Add(time.Minute)
+	cache.Prune()
+	require.Equal(t, 0, cache.Len())
+
+	response, err = NewResolver().WithServers([]string{server}).Resolve(context.Background(), names[:1])
+	require.Nil(t, err)
+	require.Equal(t, int32(7), queries.Load())
+	require.False(t, response[0].Cached)
+
+	_, err = r.Resolve(context.Background(), names[:1])
+	require.Nil(t, err)
+
+	response, err = r.Resolve(BypassCache(context.Background()), names[:1])
+	require.Nil(t, err)
+	require.Equal(t, int32(9), queries.Load())
+	require.False(t, response[0].Cached)
+
+	response, err = r.Resolve(context.Background(), names[:1])
+	require.Nil(t, err)
+	require.Equal(t, int32(9), queries.Load())
+	require.True(t, response[0].Cached)
+}
+
+func TestCacheClamps(t *testing.T) {
+	var queries atomic.Int32
+	zone := zoneHandler(t, "www.example. 60 IN A 10.0.0.1")
+	server := startServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		queries.Add(1)
+		zone(w, req)
+	}))
+
+	now := time.Now()
+	cache := NewCache().WithMaxTTL(10 * time.Second)
+	cache.now = func() time.Time { return now }
+
+	r := NewResolver().
+		WithServers([]string{server}).
+		WithCache(cache)
+
+	for range 2 {
+		_, err := r.Resolve(context.Background(), []string{"www.example"})
+		require.Nil(t, err)
+	}
+	require.Equal(t, int32(1), queries.Load())
+
+	now = now.Add(10 * time.Second)
+
+	_, err := r.Resolve(context.Background(), []string{"www.example"})
+	require.Nil(t, err)
+	require.Equal(t, int32(2), queries.Load())
+
+	cache.WithMinTTL(5 * time.Minute).WithMaxTTL(0)
+	now = now.Add(time.Hour)
+
+	_, err = r.Resolve(context.Background(), []string{"www.example"})
+	require.Nil(t, err)
+
+	now = now.Add(4 * time.Minute)
+
+	response, err := r.Resolve(context.Background(), []string{"www.example"})
+	require.Nil(t, err)
+	require.Equal(t, int32(3), queries.Load())
+	require.True(t, response[0].Cached)
+	require.Equal(t, uint32Ptr(0), response[0].TTLMin)
+}
+
+func TestCacheServers(t *testing.T) {
+	first := startServer(t, zoneHandler(t, "www.example. 60 IN A 10.0.0.1"))
+	second := startServer(t, zoneHandler(t, "www.example. 60 IN A 10.0.0.2"))
+
+	cache := NewCache()
+
+	response, err := NewResolver().WithServers([]string{first}).WithCache(cache).Resolve(context.Background(), []string{"www.example"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, response[0].Addresses)
+
+	response, err = NewResolver().WithServers([]string{second}).WithCache(cache).Resolve(context.Background(), []string{"www.example"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.2"}, response[0].Addresses)
+	require.False(t, response[0].Cached)
+
+	response, err = NewResolver().WithServers([]string{second, first}).WithCache(cache).Resolve(context.Background(), []string{"www.example"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.2"}, response[0].Addresses)
+	require.Equal(t, second, response[0].Server)
+	require.True(t, response[0].Cached)
+}
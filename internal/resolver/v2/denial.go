@@ -0,0 +1,348 @@
+package resolver
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// proveDenial checks that validated NSEC or NSEC3 records of the authority
+// section deny records of qtype at qname the way rcode tells: NXDOMAIN needs
+// neither the name nor a wildcard that would match it to exist, NODATA needs
+// the name or the wildcard it matches to exist without the type. See RFC 4035
+// section 5.4 and RFC 5155 section 8.
+func proveDenial(ns []dns.RR, qname string, qtype uint16, rcode int) error {
+	nsecs, nsec3s := denialRecords(ns)
+
+	if len(nsecs) > 0 {
+		return nsecDenial(nsecs, qname, qtype, rcode)
+	}
+
+	return nsec3Denial(nsec3s, qname, qtype, rcode)
+}
+
+// proveExpansion checks that the owner of an answer expanded from the
+// wildcard of its ancestor with the given number of labels does not exist
+// itself, see RFC 4035 section 5.3.4 and RFC 5155 section 8.8.
+func proveExpansion(ns []dns.RR, owner string, labels int) error {
+	nsecs, nsec3s := denialRecords(ns)
+
+	for _, nsec := range nsecs {
+		if nsecCovers(nsec, owner) {
+			return nil
+		}
+	}
+
+	// The next closer name is the wildcard's parent with one more label of
+	// the owner.
+	closer := trimLabels(owner, dns.CountLabel(owner)-labels-1)
+	if len(nsec3s) > 0 && nsec3Cover(nsec3s, closer) != nil {
+		return nil
+	}
+
+	return fmt.Errorf("%w: no NSEC or NSEC3 record proves %s does not exist for the wildcard answer", errBogus, owner)
+}
+
+// expansions returns owners of answer RRsets expanded from wildcards along
+// with the number of labels of the wildcards' parents their signatures tell.
+func expansions(answer []dns.RR) map[string]int {
+	result := make(map[string]int)
+	for _, rr := range answer {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok {
+			continue
+		}
+
+		owner := dns.CanonicalName(sig.Hdr.Name)
+		if int(sig.Labels) < dns.CountLabel(owner) && !strings.HasPrefix(owner, "*.") {
+			result[owner] = int(sig.Labels)
+		}
+	}
+
+	return result
+}
+
+// denialRecords returns NSEC and NSEC3 records of the authority section.
+func denialRecords(ns []dns.RR) ([]*dns.NSEC, []*dns.NSEC3) {
+	nsecs := make([]*dns.NSEC, 0)
+	nsec3s := make([]*dns.NSEC3, 0)
+
+	for _, rr := range ns {
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			nsecs = append(nsecs, rr)
+		case *dns.NSEC3:
+			// SHA-1 is the only hash algorithm defined for NSEC3.
+			if rr.Hash == dns.SHA1 {
+				nsec3s = append(nsec3s, rr)
+			}
+		}
+	}
+
+	return nsecs, nsec3s
+}
+
+// delegated tells whether the validated denial of DS records of the zone
+// proves it to be a delegation rather than a name inside its parent zone.
+func delegated(ns []dns.RR, zone string) bool {
+	nsecs, nsec3s := denialRecords(ns)
+
+	for _, nsec := range nsecs {
+		if dns.CanonicalName(nsec.Hdr.Name) == zone {
+			return slices.Contains(nsec.TypeBitMap, dns.TypeNS)
+		}
+	}
+
+	if nsec3 := nsec3Match(nsec3s, zone); nsec3 != nil {
+		return slices.Contains(nsec3.TypeBitMap, dns.TypeNS)
+	}
+
+	return false
+}
+
+func nsecDenial(nsecs []*dns.NSEC, qname string, qtype uint16, rcode int) error {
+	if rcode == dns.RcodeSuccess {
+		for _, nsec := range nsecs {
+			if dns.CanonicalName(nsec.Hdr.Name) == qname {
+				return nodata(qname, qtype, nsec.TypeBitMap)
+			}
+		}
+
+		// An empty non-terminal has no record of its own, the one before
+		// it points below it instead.
+		for _, nsec := range nsecs {
+			owner := dns.CanonicalName(nsec.Hdr.Name)
+			next := dns.CanonicalName(nsec.NextDomain)
+			if next != qname && dns.IsSubDomain(qname, next) && canonicalCompare(owner, qname) < 0 &&
+				!(delegation(owner, nsec.TypeBitMap) && dns.IsSubDomain(owner, qname)) {
+				return nil
+			}
+		}
+	}
+
+	var covering *dns.NSEC
+	for _, nsec := range nsecs {
+		if nsecCovers(nsec, qname) {
+			covering = nsec
+			break
+		}
+	}
+
+	if covering == nil {
+		return fmt.Errorf("%w: no NSEC record proves %s does not exist", errBogus, qname)
+	}
+
+	ce := closestEncloser(qname, dns.CanonicalName(covering.Hdr.Name), dns.CanonicalName(covering.NextDomain))
+	wildcard := "*." + ce
+	if ce == "." {
+		wildcard = "*."
+	}
+
+	if rcode == dns.RcodeNameError {
+		for _, nsec := range nsecs {
+			if nsecCovers(nsec, wildcard) {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("%w: no NSEC record proves wildcard %s does not exist", errBogus, wildcard)
+	}
+
+	for _, nsec := range nsecs {
+		if dns.CanonicalName(nsec.Hdr.Name) == wildcard {
+			return nodata(wildcard, qtype, nsec.TypeBitMap)
+		}
+	}
+
+	return fmt.Errorf("%w: no NSEC record proves %s has no %s records", errBogus, qname, dns.TypeToString[qtype])
+}
+
+// nsecCovers tells whether the name falls between the owner and the next
+// name of the record, so it does not exist in the zone.
+func nsecCovers(nsec *dns.NSEC, name string) bool {
+	owner := dns.CanonicalName(nsec.Hdr.Name)
+	next := dns.CanonicalName(nsec.NextDomain)
+
+	// Names below a delegation belong to another zone, and an empty
+	// non-terminal exists though it has no records of its own.
+	if delegation(owner, nsec.TypeBitMap) && owner != name && dns.IsSubDomain(owner, name) {
+		return false
+	}
+	if next != name && dns.IsSubDomain(name, next) {
+		return false
+	}
+
+	if canonicalCompare(owner, next) < 0 {
+		return canonicalCompare(owner, name) < 0 && canonicalCompare(name, next) < 0
+	}
+
+	// The last record of the zone points back to the apex.
+	return dns.IsSubDomain(next, name) && canonicalCompare(owner, name) < 0
+}
+
+func nsec3Denial(nsec3s []*dns.NSEC3, qname string, qtype uint16, rcode int) error {
+	if rcode == dns.RcodeSuccess {
+		if nsec3 := nsec3Match(nsec3s, qname); nsec3 != nil {
+			return nodata(qname, qtype, nsec3.TypeBitMap)
+		}
+	}
+
+	ce, covering := nsec3ClosestEncloser(nsec3s, qname)
+	if covering == nil {
+		return fmt.Errorf("%w: no NSEC3 record proves the closest encloser of %s", errBogus, qname)
+	}
+
+	// Opt-out spans may hide unsigned delegations, so they prove nothing
+	// about names in them but that these are not signed.
+	optOut := covering.Flags&1 == 1
+	if optOut && (rcode == dns.RcodeNameError || qtype == dns.TypeDS) {
+		return fmt.Errorf("%w: %s is covered by an opt-out NSEC3 record", errInsecure, qname)
+	}
+
+	wildcard := "*." + ce
+	if ce == "." {
+		wildcard = "*."
+	}
+
+	if rcode == dns.RcodeNameError {
+		if nsec3Cover(nsec3s, wildcard) == nil {
+			return fmt.Errorf("%w: no NSEC3 record proves wildcard %s does not exist", errBogus, wildcard)
+		}
+
+		return nil
+	}
+
+	if nsec3 := nsec3Match(nsec3s, wildcard); nsec3 != nil {
+		return nodata(wildcard, qtype, nsec3.TypeBitMap)
+	}
+
+	return fmt.Errorf("%w: no NSEC3 record proves %s has no %s records", errBogus, qname, dns.TypeToString[qtype])
+}
+
+// nsec3ClosestEncloser looks for the closest ancestor of the name proven to
+// exist whose child on the way to the name is proven not to, and returns it
+// with the record covering the child.
+func nsec3ClosestEncloser(nsec3s []*dns.NSEC3, name string) (string, *dns.NSEC3) {
+	closer := name
+	for offset, end := dns.NextLabel(name, 0); !end; offset, end = dns.NextLabel(name, offset) {
+		ce := name[offset:]
+
+		if nsec3 := nsec3Match(nsec3s, ce); nsec3 != nil {
+			if delegation(ce, nsec3.TypeBitMap) || slices.Contains(nsec3.TypeBitMap, dns.TypeDNAME) {
+				return "", nil
+			}
+
+			return ce, nsec3Cover(nsec3s, closer)
+		}
+
+		closer = ce
+	}
+
+	return "", nil
+}
+
+func nsec3Match(nsec3s []*dns.NSEC3, name string) *dns.NSEC3 {
+	for _, nsec3 := range nsec3s {
+		if nsec3.Match(name) {
+			return nsec3
+		}
+	}
+
+	return nil
+}
+
+// nsec3Cover returns the record covering the name. Cover of dns.NSEC3 holds
+// for the owner itself too, which is proven to exist rather.
+func nsec3Cover(nsec3s []*dns.NSEC3, name string) *dns.NSEC3 {
+	for _, nsec3 := range nsec3s {
+		if nsec3.Cover(name) && !nsec3.Match(name) {
+			return nsec3
+		}
+	}
+
+	return nil
+}
+
+// nodata checks the type bitmap of the name proven to exist. The record of
+// the parent side of a delegation only speaks for the DS records, and the
+// one of the child apex for anything but them.
+func nodata(name string, qtype uint16, types []uint16) error {
+	switch {
+	case qtype == dns.TypeDS && slices.Contains(types, dns.TypeSOA) && name != ".":
+		return fmt.Errorf("%w: the child zone of %s cannot deny its DS records", errBogus, name)
+	case qtype != dns.TypeDS && delegation(name, types):
+		return fmt.Errorf("%w: the parent zone of %s cannot deny its %s records", errBogus, name, dns.TypeToString[qtype])
+	case slices.Contains(types, qtype) || slices.Contains(types, dns.TypeCNAME):
+		return fmt.Errorf("%w: %s is proven to have %s records", errBogus, name, dns.TypeToString[qtype])
+	}
+
+	return nil
+}
+
+// delegation tells whether the type bitmap is the one of a zone cut as seen
+// from the parent zone.
+func delegation(name string, types []uint16) bool {
+	return name != "." && slices.Contains(types, dns.TypeNS) && !slices.Contains(types, dns.TypeSOA)
+}
+
+// closestEncloser returns the longest ancestor of the name shared with the
+// owner or the next name of the record covering it.
+func closestEncloser(name string, owner string, next string) string {
+	labels := max(dns.CompareDomainName(name, owner), dns.CompareDomainName(name, next))
+	return trimLabels(name, dns.CountLabel(name)-labels)
+}
+
+// trimLabels drops n leftmost labels of the name.
+func trimLabels(name string, n int) string {
+	offset, end := 0, false
+	for range n {
+		offset, end = dns.NextLabel(name, offset)
+	}
+
+	if end {
+		return "."
+	}
+
+	return name[offset:]
+}
+
+// canonicalCompare orders names as described in RFC 4034 section 6.1: label
+// by label from the root as lowercase octet strings, shorter names first.
+func canonicalCompare(a string, b string) int {
+	la := wireLabels(a)
+	lb := wireLabels(b)
+
+	for i := 1; i <= min(len(la), len(lb)); i++ {
+		if c := bytes.Compare(la[len(la)-i], lb[len(lb)-i]); c != 0 {
+			return c
+		}
+	}
+
+	return len(la) - len(lb)
+}
+
+// wireLabels returns labels of the name as octets with escapes resolved and
+// ASCII letters lowercased.
+func wireLabels(name string) [][]byte {
+	buf := make([]byte, 256)
+	n, err := dns.PackDomainName(dns.Fqdn(name), buf, 0, nil, false)
+	if err != nil {
+		return nil
+	}
+
+	labels := make([][]byte, 0)
+	for offset := 0; offset < n && buf[offset] != 0; offset += int(buf[offset]) + 1 {
+		label := buf[offset+1 : offset+1+int(buf[offset])]
+		for i, c := range label {
+			if 'A' <= c && c <= 'Z' {
+				label[i] = c + 'a' - 'A'
+			}
+		}
+		labels = append(labels, label)
+	}
+
+	return labels
+}
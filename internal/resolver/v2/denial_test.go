@@ -0,0 +1,230 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// nsecOf returns the NSEC record of the owner in the zone with its signature.
+func nsecOf(zone []dns.RR, owner string) []dns.RR {
+	result := make([]dns.RR, 0)
+	for _, rr := range zone {
+		rrtype := rr.Header().Rrtype
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			rrtype = sig.TypeCovered
+		}
+
+		if rrtype == dns.TypeNSEC && rr.Header().Name == owner {
+			result = append(result, rr)
+		}
+	}
+
+	return result
+}
+
+func TestDNSSECDenial(t *testing.T) {
+	parent, _, zone := signedZone(t)
+
+	// Every case answers the query for forged with rcode and the genuine
+	// signed NSEC records of proof, replayed from elsewhere in the zone.
+	for _, tc := range []struct {
+		name   string
+		mode   string
+		forged string
+		qtype  uint16
+		rcode  int
+		proof  []string
+		result string
+		dnssec string
+	}{
+		{"missing.example", ModeIpv4, "", 0, 0, nil, "NXDOMAIN", DNSSECSecure},
+		{"www.example", ModeIpv6, "", 0, 0, nil, "NOERROR", DNSSECSecure},
+		{"www.example", ModeIpv4, "www.example.", dns.TypeA, dns.RcodeNameError, []string{"expired.example.", "example."}, "SERVFAIL", DNSSECBogus},
+		{"missing.example", ModeIpv4, "missing.example.", dns.TypeA, dns.RcodeNameError, []string{"expired.example."}, "SERVFAIL", DNSSECBogus},
+		{"www.example", ModeIpv4, "www.example.", dns.TypeA, dns.RcodeSuccess, []string{"tampered.example."}, "SERVFAIL", DNSSECBogus},
+		{"www.example", ModeIpv4, "www.example.", dns.TypeA, dns.RcodeSuccess, []string{"www.example."}, "SERVFAIL", DNSSECBogus},
+		{"api.secure.example", ModeIpv4, "api.secure.example.", dns.TypeA, dns.RcodeNameError, []string{"secure.example.", "example."}, "SERVFAIL", DNSSECBogus},
+		{"api.secure.example", ModeIpv4, "secure.example.", dns.TypeDS, dns.RcodeSuccess, []string{"expired.example."}, "SERVFAIL", DNSSECBogus},
+		{"api.secure.example", ModeIpv4, "secure.example.", dns.TypeDS, dns.RcodeSuccess, []string{"secure.example."}, "SERVFAIL", DNSSECBogus},
+	} {
+		proof := make([]dns.RR, 0)
+		for _, owner := range tc.proof {
+			proof = append(proof, nsecOf(zone, owner)...)
+		}
+
+		handler := signedHandler(zone)
+		server := startServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			question := req.Question[0]
+			if question.Name != tc.forged || question.Qtype != tc.qtype {
+				handler(w, req)
+				return
+			}
+
+			msg := new(dns.Msg)
+			msg.SetReply(req)
+			msg.Rcode = tc.rcode
+			msg.Ns = proof
+
+			_ = w.WriteMsg(msg)
+		}))
+
+		r := NewResolver().
+			WithServers([]string{server}).
+			WithSearch(false).
+			WithMode(tc.mode).
+			WithDNSSEC(DNSSECRequire).
+			WithTrustAnchors([]dns.RR{parent.key.ToDS(dns.SHA256)})
+
+		description := fmt.Sprintf("%s %s by %v", tc.name, tc.mode, tc.proof)
+
+		response, err := r.Resolve(context.Background(), []string{tc.name})
+		require.Nil(t, err, description)
+		require.Equal(t, tc.result, response[0].Rcode, description)
+		require.Equal(t, tc.dnssec, response[0].DNSSEC, description)
+	}
+}
+
+func TestDNSSECWildcard(t *testing.T) {
+	parent, _, zone := signedZone(t)
+
+	for _, tc := range []struct {
+		proof  []string
+		dnssec string
+	}{
+		{[]string{"*.wild.example."}, DNSSECSecure},
+		{nil, DNSSECBogus},
+		{[]string{"www.example."}, DNSSECBogus},
+	} {
+		proof := make([]dns.RR, 0)
+		for _, owner := range tc.proof {
+			proof = append(proof, nsecOf(zone, owner)...)
+		}
+
+		handler := signedHandler(zone)
+		server := startServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			writer := &messageWriter{ResponseWriter: w}
+			handler(writer, req)
+
+			writer.msg.Ns = proof
+			_ = w.WriteMsg(writer.msg)
+		}))
+
+		r := NewResolver().
+			WithServers([]string{server}).
+			WithSearch(false).
+			WithDNSSEC(DNSSECRequire).
+			WithTrustAnchors([]dns.RR{parent.key.ToDS(dns.SHA256)})
+
+		response, err := r.Resolve(context.Background(), []string{"api.wild.example"})
+		require.Nil(t, err)
+		require.Equal(t, tc.dnssec, response[0].DNSSEC, tc.proof)
+	}
+}
+
+func TestNSECDenial(t *testing.T) {
+	nsecs := parseRRs(t,
+		"example. 60 IN NSEC a.b.example. NS SOA RRSIG NSEC DNSKEY",
+		"a.b.example. 60 IN NSEC sub.example. A RRSIG NSEC",
+		"sub.example. 60 IN NSEC example. NS RRSIG NSEC",
+	)
+
+	require.Nil(t, proveDenial(nsecs, "b.example.", dns.TypeA, dns.RcodeSuccess))
+	require.Nil(t, proveDenial(nsecs, "c.example.", dns.TypeA, dns.RcodeNameError))
+
+	err := proveDenial(nsecs, "b.example.", dns.TypeA, dns.RcodeNameError)
+	require.True(t, errors.Is(err, errBogus), err)
+
+	err = proveDenial(nsecs, "a.sub.example.", dns.TypeA, dns.RcodeNameError)
+	require.True(t, errors.Is(err, errBogus), err)
+
+	err = proveDenial(nsecs, "a.sub.example.", dns.TypeA, dns.RcodeSuccess)
+	require.True(t, errors.Is(err, errBogus), err)
+
+	err = proveDenial(nsecs, "sub.example.", dns.TypeA, dns.RcodeSuccess)
+	require.True(t, errors.Is(err, errBogus), err)
+	require.Nil(t, proveDenial(nsecs, "sub.example.", dns.TypeDS, dns.RcodeSuccess))
+}
+
+func TestNSEC3Denial(t *testing.T) {
+	types := map[string]string{
+		"example.":     "NS SOA RRSIG DNSKEY NSEC3PARAM",
+		"www.example.": "A RRSIG",
+	}
+
+	hash := func(name string) string {
+		return dns.HashName(name, dns.SHA1, 0, "")
+	}
+
+	names := []string{"example.", "www.example."}
+	slices.SortFunc(names, func(a string, b string) int {
+		return strings.Compare(hash(a), hash(b))
+	})
+
+	chain := func(flags int) []dns.RR {
+		result := make([]dns.RR, 0)
+		for i, name := range names {
+			next := names[(i+1)%len(names)]
+			result = append(result, parseRRs(t, fmt.Sprintf("%s.example. 60 IN NSEC3 1 %d 0 - %s %s",
+				strings.ToLower(hash(name)), flags, hash(next), types[name]))...)
+		}
+		return result
+	}
+
+	nsec3s := chain(0)
+	require.Nil(t, proveDenial(nsec3s, "missing.example.", dns.TypeA, dns.RcodeNameError))
+	require.Nil(t, proveDenial(nsec3s, "www.example.", dns.TypeAAAA, dns.RcodeSuccess))
+
+	err := proveDenial(nsec3s, "www.example.", dns.TypeA, dns.RcodeSuccess)
+	require.True(t, errors.Is(err, errBogus), err)
+
+	err = proveDenial(nsec3s, "www.example.", dns.TypeA, dns.RcodeNameError)
+	require.True(t, errors.Is(err, errBogus), err)
+
+	// A single record either matches the apex or covers the name, never
+	// proving both the closest encloser and the next closer name.
+	for _, nsec3 := range nsec3s {
+		err = proveDenial([]dns.RR{nsec3}, "missing.example.", dns.TypeA, dns.RcodeNameError)
+		require.True(t, errors.Is(err, errBogus), err)
+	}
+
+	err = proveDenial(chain(1), "missing.example.", dns.TypeA, dns.RcodeNameError)
+	require.True(t, errors.Is(err, errInsecure), err)
+
+	err = proveDenial(nsec3s, "missing.other.", dns.TypeA, dns.RcodeNameError)
+	require.True(t, errors.Is(err, errBogus), err)
+
+	// An answer for a.b.example. expanded from *.example. needs the next
+	// closer name b.example. to be covered.
+	require.Nil(t, proveExpansion(nsec3s, "a.b.example.", 1))
+
+	err = proveExpansion(nsec3s, "a.www.example.", 1)
+	require.True(t, errors.Is(err, errBogus), err)
+}
+
+func TestCanonicalCompare(t *testing.T) {
+	// The example of RFC 4034 section 6.1.
+	names := []string{
+		"example.",
+		"a.example.",
+		"yljkjljk.a.example.",
+		"Z.a.example.",
+		"zABC.a.EXAMPLE.",
+		"z.example.",
+		"\\001.z.example.",
+		"*.z.example.",
+		"\\200.z.example.",
+	}
+
+	for i := range names {
+		for j := range names {
+			require.Equal(t, i < j, canonicalCompare(names[i], names[j]) < 0, "%s %s", names[i], names[j])
+		}
+	}
+}
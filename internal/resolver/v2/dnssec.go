@@ -0,0 +1,535 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	DNSSECOff     = "off"
+	DNSSECReport  = "report"
+	DNSSECRequire = "require"
+	DNSSECDefault = DNSSECOff
+)
+
+// Results of local validation reported in Response.DNSSEC.
+const (
+	DNSSECSecure   = "secure"
+	DNSSECInsecure = "insecure"
+	DNSSECBogus    = "bogus"
+)
+
+// TrustAnchorsDefault are DS records of the root key signing keys.
+var TrustAnchorsDefault = []string{
+	". 172800 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBB683457104237C7F8EC8D",
+	". 172800 IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+var (
+	errInsecure = errors.New("insecure")
+	errBogus    = errors.New("bogus")
+	errNoCut    = errors.New("no zone cut")
+)
+
+// ParseTrustAnchor accepts a DS or DNSKEY record in zone file format.
+func ParseTrustAnchor(s string) (dns.RR, error) {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trust anchor %q: %+v", s, err)
+	}
+	if rr == nil {
+		return nil, fmt.Errorf("trust anchor %q is empty", s)
+	}
+
+	switch rr.(type) {
+	case *dns.DS, *dns.DNSKEY:
+		return rr, nil
+	default:
+		return nil, fmt.Errorf("trust anchor %q is neither DS nor DNSKEY record", s)
+	}
+}
+
+// WithDNSSEC sets the DO bit on queries. With DNSSECReport the AD flag of
+// upstream servers is reported in responses; with DNSSECRequire answers are
+// validated locally up to a trust anchor and the ones that fail validation
+// are reported as SERVFAIL.
+func (r *Resolver) WithDNSSEC(mode string) *Resolver {
+	r.dnssec = mode
+	return r
+}
+
+// WithTrustAnchors sets DS or DNSKEY records validation in DNSSECRequire mode
+// starts from; TrustAnchorsDefault is used when the list is empty.
+func (r *Resolver) WithTrustAnchors(anchors []dns.RR) *Resolver {
+	r.trustAnchors = anchors
+	return r
+}
+
+func (r *Resolver) setDNSSEC(msg *dns.Msg) {
+	if r.dnssec != DNSSECReport && r.dnssec != DNSSECRequire {
+		return
+	}
+
+	if opt := msg.IsEdns0(); opt != nil {
+		opt.SetDo()
+	} else {
+		msg.SetEdns0(ednsSize, true)
+	}
+
+	// Upstream servers validating on their own would hide bogus answers
+	// behind SERVFAIL, so they are asked to pass them as they are.
+	msg.CheckingDisabled = r.dnssec == DNSSECRequire
+}
+
+func dnssecStatus(err error) string {
+	if errors.Is(err, errInsecure) {
+		return DNSSECInsecure
+	}
+
+	return DNSSECBogus
+}
+
+// validate checks signatures of the answer up to a trust anchor. Names and
+// types the answer has no records of must be proven not to exist with signed
+// NSEC or NSEC3 records of the authority section.
+func (r *Resolver) validate(ctx context.Context, u *upstreams, msg *dns.Msg) error {
+	err := r.validateRRsets(ctx, u, msg.Answer)
+	if err != nil {
+		return err
+	}
+
+	expanded := expansions(msg.Answer)
+	if len(expanded) > 0 {
+		err = r.validateRRsets(ctx, u, msg.Ns)
+		if err != nil {
+			return err
+		}
+
+		for owner, labels := range expanded {
+			err = proveExpansion(msg.Ns, owner, labels)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(msg.Question) == 0 {
+		return fmt.Errorf("%w: the reply has no question", errBogus)
+	}
+
+	qtype := msg.Question[0].Qtype
+	name, answered := followChain(msg.Answer, msg.Question[0].Name, qtype)
+	name = dns.CanonicalName(name)
+	if answered && msg.Rcode == dns.RcodeSuccess {
+		return nil
+	}
+
+	denial := false
+	for _, rr := range msg.Ns {
+		switch rr.Header().Rrtype {
+		case dns.TypeNSEC, dns.TypeNSEC3:
+			denial = true
+		}
+	}
+
+	if !denial {
+		return fmt.Errorf("%w: no NSEC or NSEC3 records prove the negative answer", errInsecure)
+	}
+
+	err = r.validateRRsets(ctx, u, msg.Ns)
+	if err != nil {
+		return err
+	}
+
+	return proveDenial(msg.Ns, name, qtype, msg.Rcode)
+}
+
+type rrsetKey struct {
+	name   string
+	rrtype uint16
+}
+
+// validateRRsets requires every RRset of rrs to carry a valid signature.
+func (r *Resolver) validateRRsets(ctx context.Context, u *upstreams, rrs []dns.RR) error {
+	sets := make(map[rrsetKey][]dns.RR)
+	sigs := make(map[rrsetKey][]*dns.RRSIG)
+	keys := make([]rrsetKey, 0)
+
+	for _, rr := range rrs {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			key := rrsetKey{dns.CanonicalName(sig.Hdr.Name), sig.TypeCovered}
+			sigs[key] = append(sigs[key], sig)
+			continue
+		}
+
+		key := rrsetKey{dns.CanonicalName(rr.Header().Name), rr.Header().Rrtype}
+		if _, ok := sets[key]; !ok {
+			keys = append(keys, key)
+		}
+		sets[key] = append(sets[key], rr)
+	}
+
+	for _, key := range keys {
+		err := r.verifyRRset(ctx, u, key, sets[key], sigs[key])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyRRset looks for a signature of the RRset made by a validated key of
+// the zone the RRset belongs to.
+func (r *Resolver) verifyRRset(ctx context.Context, u *upstreams, key rrsetKey, rrset []dns.RR, sigs []*dns.RRSIG) error {
+	name := fmt.Sprintf("%s %s", key.name, dns.TypeToString[key.rrtype])
+
+	if len(sigs) == 0 {
+		return r.unsigned(ctx, u, key.name, name)
+	}
+
+	var err error
+	for _, sig := range sigs {
+		signer := dns.CanonicalName(sig.SignerName)
+		if !dns.IsSubDomain(signer, key.name) {
+			err = fmt.Errorf("%w: %s is signed by %s out of its zone", errBogus, name, signer)
+			continue
+		}
+
+		var keys []*dns.DNSKEY
+		keys, err = r.zoneKeys(ctx, u, signer)
+		if err != nil {
+			return err
+		}
+
+		err = verifySignature(name, sig, keys, rrset)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// unsigned tells RRsets of zones proven not to be signed, which are insecure,
+// from RRsets missing signatures in signed zones, which are bogus (RFC 4035
+// section 5.5). Zone cuts are followed from the closest trust anchor down to
+// the owner until one without DS records.
+func (r *Resolver) unsigned(ctx context.Context, u *upstreams, owner string, name string) error {
+	insecure := fmt.Errorf("%w: %s is not signed", errInsecure, name)
+
+	anchor := ""
+	for _, rr := range r.anchors() {
+		zone := dns.CanonicalName(rr.Header().Name)
+		if dns.IsSubDomain(zone, owner) && (anchor == "" || dns.CountLabel(zone) > dns.CountLabel(anchor)) {
+			anchor = zone
+		}
+	}
+
+	if anchor == "" {
+		return insecure
+	}
+
+	zones := make([]string, 0)
+	for zone := owner; zone != anchor; {
+		zones = append(zones, zone)
+
+		offset, end := dns.NextLabel(zone, 0)
+		if end {
+			break
+		}
+		zone = zone[offset:]
+	}
+	zones = append(zones, anchor)
+	slices.Reverse(zones)
+
+	for _, zone := range zones {
+		_, err := r.zoneKeys(ctx, u, zone)
+		switch {
+		case err == nil, errors.Is(err, errNoCut):
+		case errors.Is(err, errInsecure):
+			return insecure
+		default:
+			return err
+		}
+	}
+
+	return fmt.Errorf("%w: %s is not signed in a signed zone", errBogus, name)
+}
+
+func verifySignature(name string, sig *dns.RRSIG, keys []*dns.DNSKEY, rrset []dns.RR) error {
+	if !sig.ValidityPeriod(time.Now()) {
+		return fmt.Errorf("%w: signature of %s by key %d is expired or not yet valid", errBogus, name, sig.KeyTag)
+	}
+
+	for _, key := range keys {
+		if key.KeyTag() == sig.KeyTag && sig.Verify(key, rrset) == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: signature of %s by key %d does not verify", errBogus, name, sig.KeyTag)
+}
+
+// keysProbe keeps validated keys of a zone, so every zone is validated once
+// per call no matter how many of its names are resolved. done is closed once
+// keys are fetched.
+type keysProbe struct {
+	done chan struct{}
+	keys []*dns.DNSKEY
+	err  error
+}
+
+// keysProbe returns the probe of the zone and whether it is new, so the
+// caller has to fetch keys and close done.
+func (u *upstreams) keysProbe(zone string) (*keysProbe, bool) {
+	u.keysMu.Lock()
+	defer u.keysMu.Unlock()
+
+	probe, ok := u.keys[zone]
+	if !ok {
+		probe = &keysProbe{done: make(chan struct{})}
+		u.keys[zone] = probe
+	}
+
+	return probe, !ok
+}
+
+// fetchingKeys is the context key of zones whose keys are being fetched down
+// the stack, which must not wait for themselves.
+type fetchingKeys struct{}
+
+// zoneKeys returns the DNSKEY RRset of the zone once it is signed by a key
+// matching a trust anchor or a DS record validated in the parent zone.
+func (r *Resolver) zoneKeys(ctx context.Context, u *upstreams, zone string) ([]*dns.DNSKEY, error) {
+	fetching, _ := ctx.Value(fetchingKeys{}).([]string)
+	if slices.Contains(fetching, zone) {
+		return nil, fmt.Errorf("%w: validation of %s keys depends on the keys themselves", errBogus, zone)
+	}
+
+	probe, fetch := u.keysProbe(zone)
+	if fetch {
+		fetching = append(slices.Clone(fetching), zone)
+		probe.keys, probe.err = r.fetchKeys(context.WithValue(ctx, fetchingKeys{}, fetching), u, zone)
+		close(probe.done)
+	}
+
+	select {
+	case <-probe.done:
+		return probe.keys, probe.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (r *Resolver) fetchKeys(ctx context.Context, u *upstreams, zone string) ([]*dns.DNSKEY, error) {
+	anchors := make([]dns.RR, 0)
+	for _, anchor := range r.anchors() {
+		if dns.CanonicalName(anchor.Header().Name) == zone {
+			anchors = append(anchors, anchor)
+		}
+	}
+
+	if len(anchors) == 0 {
+		if zone == "." {
+			return nil, fmt.Errorf("%w: there is no trust anchor for the chain", errInsecure)
+		}
+
+		ds, err := r.fetchDS(ctx, u, zone)
+		if err != nil {
+			return nil, err
+		}
+		anchors = ds
+	}
+
+	reply, err := r.exchange(ctx, u, r.newDNSSECQuery(zone, dns.TypeDNSKEY))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*dns.DNSKEY, 0)
+	rrset := make([]dns.RR, 0)
+	sigs := make([]*dns.RRSIG, 0)
+
+	for _, rr := range reply.msg.Answer {
+		if dns.CanonicalName(rr.Header().Name) != zone {
+			continue
+		}
+
+		switch rr := rr.(type) {
+		case *dns.DNSKEY:
+			keys = append(keys, rr)
+			rrset = append(rrset, rr)
+		case *dns.RRSIG:
+			if rr.TypeCovered == dns.TypeDNSKEY {
+				sigs = append(sigs, rr)
+			}
+		}
+	}
+
+	name := zone + " DNSKEY"
+	trusted := make([]*dns.DNSKEY, 0)
+	for _, key := range keys {
+		if anchored(key, anchors) {
+			trusted = append(trusted, key)
+		}
+	}
+
+	if len(trusted) == 0 {
+		return nil, fmt.Errorf("%w: no key of %s matches its DS or trust anchor", errBogus, name)
+	}
+
+	err = fmt.Errorf("%w: %s is not signed by a trusted key", errBogus, name)
+	for _, sig := range sigs {
+		err = verifySignature(name, sig, trusted, rrset)
+		if err == nil {
+			return keys, nil
+		}
+	}
+
+	return nil, err
+}
+
+// fetchDS returns DS records of the zone validated with keys of the zone
+// that signed them, which is an ancestor of the zone.
+func (r *Resolver) fetchDS(ctx context.Context, u *upstreams, zone string) ([]dns.RR, error) {
+	reply, err := r.exchange(ctx, u, r.newDNSSECQuery(zone, dns.TypeDS))
+	if err != nil {
+		return nil, err
+	}
+
+	if reply.msg.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("%w: DS of %s answered with %s", errBogus, zone, dns.RcodeToString[reply.msg.Rcode])
+	}
+
+	ds := make([]dns.RR, 0)
+	sigs := make([]*dns.RRSIG, 0)
+
+	for _, rr := range signedAbove(reply.msg.Answer, zone) {
+		if dns.CanonicalName(rr.Header().Name) != zone {
+			continue
+		}
+
+		switch rr := rr.(type) {
+		case *dns.DS:
+			ds = append(ds, rr)
+		case *dns.RRSIG:
+			if rr.TypeCovered == dns.TypeDS {
+				sigs = append(sigs, rr)
+			}
+		}
+	}
+
+	if len(ds) == 0 {
+		denial := reply.msg.Copy()
+		denial.Answer = signedAbove(denial.Answer, zone)
+		denial.Ns = signedAbove(denial.Ns, zone)
+
+		err = r.validate(ctx, u, denial)
+		if err != nil {
+			return nil, err
+		}
+
+		if !delegated(denial.Ns, zone) {
+			return nil, fmt.Errorf("%w: %s is not a delegation", errNoCut, zone)
+		}
+
+		return nil, fmt.Errorf("%w: %s has no DS records", errInsecure, zone)
+	}
+
+	err = r.verifyRRset(ctx, u, rrsetKey{zone, dns.TypeDS}, ds, sigs)
+	if err != nil {
+		return nil, err
+	}
+
+	return ds, nil
+}
+
+func (r *Resolver) anchors() []dns.RR {
+	if len(r.trustAnchors) > 0 {
+		return r.trustAnchors
+	}
+
+	anchors := make([]dns.RR, 0, len(TrustAnchorsDefault))
+	for _, anchor := range TrustAnchorsDefault {
+		rr, err := ParseTrustAnchor(anchor)
+		if err == nil {
+			anchors = append(anchors, rr)
+		}
+	}
+
+	return anchors
+}
+
+// anchored tells whether the key matches one of DS or DNSKEY records.
+func anchored(key *dns.DNSKEY, anchors []dns.RR) bool {
+	for _, anchor := range anchors {
+		switch anchor := anchor.(type) {
+		case *dns.DS:
+			ds := key.ToDS(anchor.DigestType)
+			if ds != nil && ds.KeyTag == anchor.KeyTag && ds.Algorithm == anchor.Algorithm &&
+				strings.EqualFold(ds.Digest, anchor.Digest) {
+				return true
+			}
+		case *dns.DNSKEY:
+			if key.Flags == anchor.Flags && key.Algorithm == anchor.Algorithm && key.PublicKey == anchor.PublicKey {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (r *Resolver) newDNSSECQuery(name string, qtype uint16) *dns.Msg {
+	query := newQuery(name, qtype)
+	r.setDNSSEC(query)
+	return query
+}
+
+// validated tells whether answers with the rcode are subject to validation.
+func validated(rcode int) bool {
+	return rcode == dns.RcodeSuccess || rcode == dns.RcodeNameError
+}
+
+// stripSignatures drops RRSIG records that come along with the answer when
+// the DO bit is set.
+func stripSignatures(answer []dns.RR, qtype uint16) []dns.RR {
+	if qtype == dns.TypeRRSIG {
+		return answer
+	}
+
+	result := make([]dns.RR, 0, len(answer))
+	for _, rr := range answer {
+		if rr.Header().Rrtype != dns.TypeRRSIG {
+			result = append(result, rr)
+		}
+	}
+
+	return result
+}
+
+// signedAbove drops signatures made by other zones than ancestors of the
+// zone: the zone cannot vouch for its own delegation, and validation of its
+// keys must not wait for itself.
+func signedAbove(rrs []dns.RR, zone string) []dns.RR {
+	result := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			signer := dns.CanonicalName(sig.SignerName)
+			if signer == zone || !dns.IsSubDomain(signer, zone) {
+				continue
+			}
+		}
+
+		result = append(result, rr)
+	}
+
+	return result
+}
@@ -0,0 +1,345 @@
+package resolver
+
+import (
+	"context"
+	"crypto"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// signer is a zone signing key of a locally signed test zone.
+type signer struct {
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newSigner(t *testing.T, zone string) *signer {
+	t.Helper()
+
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+
+	priv, err := key.Generate(256)
+	require.Nil(t, err)
+
+	return &signer{key: key, priv: priv.(crypto.Signer)}
+}
+
+// sign returns the RRSIG of rrset valid in the given period around now.
+func (s *signer) sign(t *testing.T, rrset []dns.RR, from time.Duration, until time.Duration) *dns.RRSIG {
+	t.Helper()
+
+	now := time.Now()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Ttl: rrset[0].Header().Ttl},
+		KeyTag:     s.key.KeyTag(),
+		SignerName: s.key.Hdr.Name,
+		Algorithm:  s.key.Algorithm,
+		Inception:  uint32(now.Add(from).Unix()),
+		Expiration: uint32(now.Add(until).Unix()),
+	}
+
+	require.Nil(t, sig.Sign(s.priv, rrset))
+	return sig
+}
+
+func (s *signer) signed(t *testing.T, records ...string) []dns.RR {
+	t.Helper()
+
+	rrset := parseRRs(t, records...)
+	return append(rrset, s.sign(t, rrset, -time.Hour, time.Hour))
+}
+
+func parseRRs(t *testing.T, records ...string) []dns.RR {
+	t.Helper()
+
+	result := make([]dns.RR, 0, len(records))
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		require.Nil(t, err)
+		result = append(result, rr)
+	}
+
+	return result
+}
+
+// signedHandler answers with records of the zone and their signatures, and
+// expands wildcards of the zone for names missing from it. Names missing from
+// the zone, types missing from names and expanded answers get NSEC records of
+// the zone along with NXDOMAIN or NOERROR.
+func signedHandler(zone []dns.RR) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(req)
+		msg.Rcode = dns.RcodeNameError
+
+		question := req.Question[0]
+		answer := func(owner string) {
+			for _, rr := range zone {
+				if !strings.EqualFold(rr.Header().Name, owner) {
+					continue
+				}
+
+				msg.Rcode = dns.RcodeSuccess
+				rrtype := rr.Header().Rrtype
+				if sig, ok := rr.(*dns.RRSIG); ok {
+					rrtype = sig.TypeCovered
+				}
+
+				if rrtype == question.Qtype {
+					rr = dns.Copy(rr)
+					rr.Header().Name = question.Name
+					msg.Answer = append(msg.Answer, rr)
+				}
+			}
+		}
+
+		answer(question.Name)
+
+		expanded := false
+		if offset, end := dns.NextLabel(question.Name, 0); msg.Rcode == dns.RcodeNameError && !end {
+			answer("*." + question.Name[offset:])
+			expanded = msg.Rcode == dns.RcodeSuccess
+		}
+
+		if len(msg.Answer) == 0 || expanded {
+			for _, rr := range zone {
+				rrtype := rr.Header().Rrtype
+				if sig, ok := rr.(*dns.RRSIG); ok {
+					rrtype = sig.TypeCovered
+				}
+
+				if rrtype == dns.TypeNSEC {
+					msg.Ns = append(msg.Ns, rr)
+				}
+			}
+		}
+
+		// Replies too large for UDP are truncated to be retried over TCP.
+		if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+			size := dns.MinMsgSize
+			if opt := req.IsEdns0(); opt != nil {
+				size = int(opt.UDPSize())
+			}
+			msg.Truncate(size)
+		}
+
+		_ = w.WriteMsg(msg)
+	}
+}
+
+// signedZone returns signers of example. and its child secure.example. along
+// with records of both zones: signed, unsigned, tampered, expired and wildcard
+// ones, and the unsigned delegation of plain.example.
+func signedZone(t *testing.T) (*signer, *signer, []dns.RR) {
+	t.Helper()
+
+	parent := newSigner(t, "example.")
+	child := newSigner(t, "secure.example.")
+
+	tampered := parent.signed(t, "tampered.example. 60 IN A 10.0.0.3")
+	tampered[0] = parseRRs(t, "tampered.example. 60 IN A 10.0.0.66")[0]
+
+	expired := parseRRs(t, "expired.example. 60 IN A 10.0.0.4")
+	expired = append(expired, parent.sign(t, expired, -2*time.Hour, -time.Hour))
+
+	zone := make([]dns.RR, 0)
+	zone = append(zone, parent.key, parent.sign(t, []dns.RR{parent.key}, -time.Hour, time.Hour))
+	zone = append(zone, parent.signed(t, "www.example. 60 IN A 10.0.0.1")...)
+	zone = append(zone, parent.signed(t, child.key.ToDS(dns.SHA256).String())...)
+	zone = append(zone, parent.signed(t, "*.wild.example. 60 IN A 10.0.0.5")...)
+	zone = append(zone, parent.signed(t, "example. 60 IN NSEC expired.example. NS SOA RRSIG NSEC DNSKEY")...)
+	zone = append(zone, parent.signed(t, "expired.example. 60 IN NSEC plain.example. A RRSIG NSEC")...)
+	zone = append(zone, parent.signed(t, "plain.example. 60 IN NSEC secure.example. NS RRSIG NSEC")...)
+	zone = append(zone, parent.signed(t, "secure.example. 60 IN NSEC tampered.example. NS DS RRSIG NSEC")...)
+	zone = append(zone, parent.signed(t, "tampered.example. 60 IN NSEC unsigned.example. A RRSIG NSEC")...)
+	zone = append(zone, parent.signed(t, "unsigned.example. 60 IN NSEC *.wild.example. A RRSIG NSEC")...)
+	zone = append(zone, parent.signed(t, "*.wild.example. 60 IN NSEC www.example. A RRSIG NSEC")...)
+	zone = append(zone, parent.signed(t, "www.example. 60 IN NSEC example. A RRSIG NSEC")...)
+	zone = append(zone, parseRRs(t, "unsigned.example. 60 IN A 10.0.0.2")...)
+	zone = append(zone, parseRRs(t, "plain.example. 60 IN NS ns.plain.example.", "www.plain.example. 60 IN A 10.0.2.1")...)
+	zone = append(zone, tampered...)
+	zone = append(zone, expired...)
+	zone = append(zone, child.key, child.sign(t, []dns.RR{child.key}, -time.Hour, time.Hour))
+	zone = append(zone, child.signed(t, "api.secure.example. 60 IN A 10.0.1.1")...)
+
+	return parent, child, zone
+}
+
+func TestDNSSEC(t *testing.T) {
+	parent, child, zone := signedZone(t)
+
+	var mu sync.Mutex
+	flags := make([]bool, 0)
+	handler := signedHandler(zone)
+	server := startServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		opt := req.IsEdns0()
+
+		mu.Lock()
+		flags = append(flags, opt != nil && opt.Do() && req.CheckingDisabled)
+		mu.Unlock()
+
+		handler(w, req)
+	}))
+
+	r := NewResolver().
+		WithServers([]string{server}).
+		WithSearch(false).
+		WithDNSSEC(DNSSECRequire).
+		WithTrustAnchors([]dns.RR{parent.key.ToDS(dns.SHA256)})
+
+	names := []string{"www.example", "api.secure.example", "unsigned.example", "tampered.example", "expired.example", "missing.example", "www.plain.example", "a.wild.example"}
+
+	response, err := r.Resolve(context.Background(), names)
+	require.Nil(t, err)
+
+	type result struct {
+		rcode     string
+		dnssec    string
+		addresses []string
+	}
+
+	results := make(map[string]result)
+	for _, response := range response {
+		results[response.Name] = result{response.Rcode, response.DNSSEC, response.Addresses}
+		require.False(t, response.AD, response.Name)
+	}
+
+	require.Equal(t, map[string]result{
+		"www.example":        {"NOERROR", DNSSECSecure, []string{"10.0.0.1"}},
+		"api.secure.example": {"NOERROR", DNSSECSecure, []string{"10.0.1.1"}},
+		"unsigned.example":   {"SERVFAIL", DNSSECBogus, []string{}},
+		"tampered.example":   {"SERVFAIL", DNSSECBogus, []string{}},
+		"expired.example":    {"SERVFAIL", DNSSECBogus, []string{}},
+		"missing.example":    {"NXDOMAIN", DNSSECSecure, []string{}},
+		"www.plain.example":  {"SERVFAIL", DNSSECInsecure, []string{}},
+		"a.wild.example":     {"NOERROR", DNSSECSecure, []string{"10.0.0.5"}},
+	}, results)
+
+	require.Contains(t, response[2].Errors[0], "not signed in a signed zone")
+	require.Contains(t, response[3].Errors[0], "does not verify")
+	require.Contains(t, response[4].Errors[0], "expired")
+
+	mu.Lock()
+	require.NotContains(t, flags, false)
+	mu.Unlock()
+
+	stranger := newSigner(t, "example.")
+	r.WithTrustAnchors([]dns.RR{stranger.key})
+
+	response, err = r.Resolve(context.Background(), []string{"www.example", "api.secure.example"})
+	require.Nil(t, err)
+	for _, response := range response {
+		require.Equal(t, "SERVFAIL", response.Rcode, response.Name)
+		require.Equal(t, DNSSECBogus, response.DNSSEC, response.Name)
+	}
+
+	r.WithTrustAnchors([]dns.RR{child.key})
+
+	response, err = r.Resolve(context.Background(), []string{"www.example"})
+	require.Nil(t, err)
+	require.Equal(t, DNSSECInsecure, response[0].DNSSEC)
+}
+
+func TestDNSSECReentry(t *testing.T) {
+	parent, child, zone := signedZone(t)
+
+	// The reply denying DS records of secure.example. carries records signed
+	// by secure.example. itself, whose keys wait for this very reply.
+	answer := child.signed(t, "api.secure.example. 60 IN A 10.0.1.1")
+	handler := signedHandler(zone)
+	server := startServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		question := req.Question[0]
+		if question.Name != "secure.example." || question.Qtype != dns.TypeDS {
+			handler(w, req)
+			return
+		}
+
+		msg := new(dns.Msg)
+		msg.SetReply(req)
+		msg.Answer = answer
+		msg.Ns = nsecOf(zone, "expired.example.")
+
+		_ = w.WriteMsg(msg)
+	}))
+
+	r := NewResolver().
+		WithServers([]string{server}).
+		WithSearch(false).
+		WithDNSSEC(DNSSECRequire).
+		WithTrustAnchors([]dns.RR{parent.key.ToDS(dns.SHA256)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	response, err := r.Resolve(ctx, []string{"api.secure.example"})
+	require.Nil(t, err)
+	require.Equal(t, "SERVFAIL", response[0].Rcode)
+	require.Equal(t, DNSSECBogus, response[0].DNSSEC)
+}
+
+func TestDNSSECReport(t *testing.T) {
+	zone := parseRRs(t, "www.example. 60 IN A 10.0.0.1")
+
+	var mu sync.Mutex
+	flags := make([]bool, 0)
+	server := startServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		opt := req.IsEdns0()
+		do := opt != nil && opt.Do()
+
+		mu.Lock()
+		flags = append(flags, do)
+		mu.Unlock()
+
+		msg := new(dns.Msg)
+		msg.SetReply(req)
+		msg.Answer = zone
+		msg.AuthenticatedData = do
+
+		_ = w.WriteMsg(msg)
+	}))
+
+	r := NewResolver().
+		WithServers([]string{server}).
+		WithSearch(false)
+
+	response, err := r.Resolve(context.Background(), []string{"www.example"})
+	require.Nil(t, err)
+	require.False(t, response[0].AD)
+	require.Empty(t, response[0].DNSSEC)
+
+	r.WithDNSSEC(DNSSECReport)
+
+	response, err = r.Resolve(context.Background(), []string{"www.example"})
+	require.Nil(t, err)
+	require.True(t, response[0].AD)
+	require.Empty(t, response[0].DNSSEC)
+
+	mu.Lock()
+	require.Equal(t, []bool{false, true}, flags)
+	mu.Unlock()
+}
+
+func TestParseTrustAnchor(t *testing.T) {
+	for _, anchor := range TrustAnchorsDefault {
+		rr, err := ParseTrustAnchor(anchor)
+		require.Nil(t, err)
+		require.IsType(t, &dns.DS{}, rr)
+	}
+
+	_, err := ParseTrustAnchor("example. 60 IN A 10.0.0.1")
+	require.NotNil(t, err)
+
+	_, err = ParseTrustAnchor("example. IN DS twenty 8 2 00")
+	require.NotNil(t, err)
+}
@@ -0,0 +1,148 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	SchemeHTTPS      = "https"
+	PathDoHDefault   = "/dns-query"
+	DoHMethodDefault = http.MethodPost
+	mimeDNSMessage   = "application/dns-message"
+
+	// dohIdleTimeout bounds idle connections kept by a client in case
+	// nothing closes them.
+	dohIdleTimeout = 90 * time.Second
+)
+
+// parseDoHURL validates the URL of a DNS-over-HTTPS upstream and sets the
+// default path when it is missing.
+func parseDoHURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid server address %q: %+v", s, err)
+	}
+
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid server address %q", s)
+	}
+
+	if _, err := parseHostPort(u.Host, "443"); err != nil {
+		return "", err
+	}
+
+	if u.Path == "" {
+		u.Path = PathDoHDefault
+	}
+
+	return u.String(), nil
+}
+
+func (r *Resolver) WithDoHMethod(m string) *Resolver {
+	r.dohMethod = m
+	return r
+}
+
+// WithDoHHeaders sets extra HTTP headers of DNS-over-HTTPS requests, e.g.
+// credentials of an authenticated gateway.
+func (r *Resolver) WithDoHHeaders(h map[string]string) *Resolver {
+	r.dohHeaders = h
+	return r
+}
+
+func (r *Resolver) newDoHClient(server string, timeout time.Duration) (*http.Client, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   r.tlsConfigFor(u.Hostname()),
+			ForceAttemptHTTP2: true,
+			IdleConnTimeout:   dohIdleTimeout,
+		},
+	}, nil
+}
+
+// exchangeDoH sends the query in wire format as described in RFC 8484. The
+// message ID is zeroed for the sake of HTTP caches and restored in the
+// response.
+func (r *Resolver) exchangeDoH(ctx context.Context, client *http.Client, msg *dns.Msg, server string) (*dns.Msg, error) {
+	query := msg.Copy()
+	query.Id = 0
+
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	var request *http.Request
+	if r.dohMethod == http.MethodGet {
+		u, err := url.Parse(server)
+		if err != nil {
+			return nil, err
+		}
+
+		values := u.Query()
+		values.Set("dns", base64.RawURLEncoding.EncodeToString(packed))
+		u.RawQuery = values.Encode()
+
+		request, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		request, err = http.NewRequestWithContext(ctx, http.MethodPost, server, bytes.NewReader(packed))
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", mimeDNSMessage)
+	}
+
+	request.Header.Set("Accept", mimeDNSMessage)
+	for key, value := range r.dohHeaders {
+		request.Header.Set(key, value)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// nolint:errcheck
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s from %s", response.Status, server)
+	}
+
+	if contentType := response.Header.Get("Content-Type"); !strings.HasPrefix(contentType, mimeDNSMessage) {
+		return nil, fmt.Errorf("unexpected content type %q from %s", contentType, server)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(dns.Msg)
+	err = result.Unpack(body)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Id = msg.Id
+	return result, nil
+}
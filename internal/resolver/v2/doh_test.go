@@ -0,0 +1,183 @@
+package resolver
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pabateman/dns-lookuper/internal/resolver"
+)
+
+// messageWriter keeps the message written by a dns.Handler.
+type messageWriter struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (w *messageWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func (w *messageWriter) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// startDoHServer serves handler over DNS-over-HTTPS with HTTP/2 enabled and
+// returns the server along with the path of its CA bundle and the function
+// reporting the number of open connections. Every request is passed to
+// inspect before it is handled.
+func startDoHServer(t *testing.T, handler dns.Handler, inspect func(*http.Request)) (*httptest.Server, string, func() int) {
+	t.Helper()
+
+	var open atomic.Int32
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		inspect(req)
+
+		var packed []byte
+		var err error
+		if req.Method == http.MethodGet {
+			packed, err = base64.RawURLEncoding.DecodeString(req.URL.Query().Get("dns"))
+		} else {
+			packed, err = io.ReadAll(req.Body)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		query := new(dns.Msg)
+		if err := query.Unpack(packed); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writer := &messageWriter{}
+		handler.ServeDNS(writer, query)
+
+		response, err := writer.msg.Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", mimeDNSMessage)
+		_, _ = w.Write(response)
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			open.Add(1)
+		case http.StateClosed, http.StateHijacked:
+			open.Add(-1)
+		}
+	}
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	caFile := path.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o644)
+	require.Nil(t, err)
+
+	return server, caFile, func() int { return int(open.Load()) }
+}
+
+func TestDoH(t *testing.T) {
+	requests := make(chan *http.Request, 16)
+	server, caFile, open := startDoHServer(t,
+		zoneHandler(t, "gateway.example. 60 IN A 10.0.0.1"),
+		func(req *http.Request) { requests <- req },
+	)
+
+	upstream, err := ParseServer(server.URL)
+	require.Nil(t, err)
+	require.Equal(t, server.URL+PathDoHDefault, upstream)
+
+	config, err := NewTLSConfig("", caFile, nil)
+	require.Nil(t, err)
+
+	r := NewResolver().
+		WithServers([]string{upstream}).
+		WithAttempts(1).
+		WithTimeout(time.Second).
+		WithTLSConfig(config).
+		WithDoHHeaders(map[string]string{"Authorization": "Bearer secret"})
+
+	response, err := r.Resolve(context.Background(), []string{"gateway.example", "missing.example"})
+	require.Nil(t, err)
+
+	require.Equal(t, []Response{
+		{
+			Name:      "gateway.example",
+			Addresses: []string{"10.0.0.1"},
+			Server:    upstream,
+			Rcode:     "NOERROR",
+			TTLMin:    uint32Ptr(60),
+			TTLMax:    uint32Ptr(60),
+		},
+		{
+			Name:      "missing.example",
+			Addresses: []string{},
+			Server:    upstream,
+			Rcode:     "NXDOMAIN",
+		},
+	}, stripTiming(response))
+	require.Len(t, resolver.FilterResponsesNoerror(response), 1)
+	require.Len(t, resolver.FilterResponsesNxdomain(response), 1)
+
+	// Connections of the call are closed once it is done.
+	require.Eventually(t, func() bool { return open() == 0 }, time.Second, 10*time.Millisecond)
+
+	req := <-requests
+	require.Equal(t, http.MethodPost, req.Method)
+	require.Equal(t, 2, req.ProtoMajor)
+	require.Equal(t, PathDoHDefault, req.URL.Path)
+	require.Equal(t, mimeDNSMessage, req.Header.Get("Content-Type"))
+	require.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+	<-requests
+
+	response, err = r.WithDoHMethod(http.MethodGet).Resolve(context.Background(), []string{"gateway.example"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, response[0].Addresses)
+
+	req = <-requests
+	require.Equal(t, http.MethodGet, req.Method)
+	require.NotEmpty(t, req.URL.Query().Get("dns"))
+
+	_, err = r.WithTLSConfig(nil).Resolve(context.Background(), []string{"gateway.example"})
+	require.NotNil(t, err)
+}
+
+func TestDoHURL(t *testing.T) {
+	valid := map[string]string{
+		"https://dns.example":                 "https://dns.example/dns-query",
+		"https://dns.example/resolve":         "https://dns.example/resolve",
+		"https://[::1]:8443/dns-query":        "https://[::1]:8443/dns-query",
+		"https://10.0.0.1/dns-query?tenant=a": "https://10.0.0.1/dns-query?tenant=a",
+	}
+
+	for input, expected := range valid {
+		actual, err := ParseServer(input)
+		require.Nil(t, err, input)
+		require.Equal(t, expected, actual)
+	}
+
+	for _, input := range []string{"https://", "https://invalid$name/dns-query", "https://dns.example:0"} {
+		_, err := ParseServer(input)
+		require.NotNil(t, err, input)
+	}
+}
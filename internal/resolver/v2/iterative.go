@@ -0,0 +1,251 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const MaxDepthDefault = 24
+
+// RootHintsDefault are IPv4 addresses of the root servers a to m.
+var RootHintsDefault = []string{
+	"198.41.0.4:53",
+	"170.247.170.2:53",
+	"192.33.4.12:53",
+	"199.7.91.13:53",
+	"192.203.230.10:53",
+	"192.5.5.241:53",
+	"192.112.36.4:53",
+	"198.97.190.53:53",
+	"192.36.148.17:53",
+	"192.58.128.30:53",
+	"193.0.14.129:53",
+	"199.7.83.42:53",
+	"202.12.27.33:53",
+}
+
+var errMaxDepth = errors.New("max depth of iterative resolution exceeded")
+
+// WithIterative makes the resolver walk from the root servers down to the
+// authoritative servers of every name instead of asking recursive resolvers.
+func (r *Resolver) WithIterative(i bool) *Resolver {
+	r.iterative = i
+	return r
+}
+
+// WithRootHints sets servers in "host:port" form iterative resolution starts
+// from; RootHintsDefault is used when the list is empty.
+func (r *Resolver) WithRootHints(h []string) *Resolver {
+	if len(h) == 0 {
+		h = RootHintsDefault
+	}
+
+	r.rootHints = h
+	return r
+}
+
+// WithMaxDepth limits the number of queries sent to resolve a name
+// iteratively, counting referrals, CNAMEs leading to other zones and lookups
+// of nameservers with no glue.
+func (r *Resolver) WithMaxDepth(d int) *Resolver {
+	r.maxDepth = max(d, 1)
+	return r
+}
+
+// iterate follows referrals from the root hints until a server answers the
+// query authoritatively. CNAMEs pointing out of the zone of the answer are
+// chased from the root again, and the whole chain ends up in the answer
+// section of the returned message. Every query takes one from depth.
+func (r *Resolver) iterate(ctx context.Context, u *upstreams, msg *dns.Msg, depth *int) (*reply, error) {
+	question := msg.Question[0]
+	name := question.Name
+	zone := "."
+	servers := u.servers
+
+	chain := make([]dns.RR, 0)
+	var rtt time.Duration
+	var timestamp time.Time
+
+	for {
+		if *depth <= 0 {
+			return nil, fmt.Errorf("%w for %s", errMaxDepth, trimDot(question.Name))
+		}
+		*depth--
+
+		query := msg.Copy()
+		query.Id = dns.Id()
+		query.RecursionDesired = false
+		query.Question[0].Name = name
+
+		current := servers
+		result, err := r.exchangeServers(ctx, u, query, current, func() []string { return current })
+		if err != nil {
+			return nil, err
+		}
+
+		if timestamp.IsZero() {
+			timestamp = result.timestamp
+		}
+		rtt += result.rtt
+		response := result.msg
+
+		if next, ok := referral(response, zone, name); ok {
+			servers, err = r.delegation(ctx, u, response, next, depth)
+			if err != nil {
+				return nil, err
+			}
+			zone = next
+			continue
+		}
+
+		chain = append(chain, response.Answer...)
+
+		target, answered := followChain(response.Answer, name, question.Qtype)
+		if response.Rcode != dns.RcodeSuccess || answered || strings.EqualFold(target, name) {
+			final := response.Copy()
+			final.Id = msg.Id
+			final.Question = msg.Question
+			final.Answer = chain
+
+			return &reply{
+				msg:       final,
+				server:    result.server,
+				rtt:       rtt,
+				timestamp: timestamp,
+			}, nil
+		}
+
+		name = target
+		zone = "."
+		servers = u.servers
+	}
+}
+
+// referral returns the zone the response delegates the name to; only zones
+// below the current one count, so servers cannot send the resolver back up.
+func referral(response *dns.Msg, zone string, name string) (string, bool) {
+	if response.Rcode != dns.RcodeSuccess || len(response.Answer) > 0 {
+		return "", false
+	}
+
+	next := ""
+	for _, rr := range response.Ns {
+		switch rr.(type) {
+		case *dns.SOA:
+			return "", false
+		case *dns.NS:
+			owner := rr.Header().Name
+			if dns.IsSubDomain(zone, owner) && dns.IsSubDomain(owner, name) && !strings.EqualFold(owner, zone) {
+				next = owner
+			}
+		}
+	}
+
+	return next, next != ""
+}
+
+// delegation returns addresses of nameservers of the zone from glue records,
+// IPv4 first, or resolves them when the referral has no glue. Glue is only
+// taken for nameservers at or below the zone, as the server of the referral
+// has no say about addresses of other names; those are resolved on their
+// own.
+func (r *Resolver) delegation(ctx context.Context, u *upstreams, response *dns.Msg, zone string, depth *int) ([]string, error) {
+	nameservers := make([]string, 0)
+	for _, rr := range response.Ns {
+		if ns, ok := rr.(*dns.NS); ok && strings.EqualFold(ns.Hdr.Name, zone) {
+			nameservers = append(nameservers, ns.Ns)
+		}
+	}
+
+	inZone := func(name string) bool {
+		for _, ns := range nameservers {
+			if strings.EqualFold(ns, name) && dns.IsSubDomain(zone, name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	v4 := make([]string, 0)
+	v6 := make([]string, 0)
+	for _, rr := range response.Extra {
+		if !inZone(rr.Header().Name) {
+			continue
+		}
+
+		switch rr := rr.(type) {
+		case *dns.A:
+			v4 = append(v4, net.JoinHostPort(rr.A.String(), r.authPort))
+		case *dns.AAAA:
+			v6 = append(v6, net.JoinHostPort(rr.AAAA.String(), r.authPort))
+		}
+	}
+
+	if servers := append(v4, v6...); len(servers) > 0 {
+		return servers, nil
+	}
+
+	for _, ns := range nameservers {
+		// Names in the zone cannot be resolved without its servers.
+		if dns.IsSubDomain(zone, ns) {
+			continue
+		}
+
+		result, err := r.iterate(ctx, u, newQuery(ns, dns.TypeA), depth)
+		if errors.Is(err, errMaxDepth) || ctx.Err() != nil {
+			return nil, err
+		}
+		if err != nil {
+			continue
+		}
+
+		servers := make([]string, 0)
+		for _, rr := range result.msg.Answer {
+			if a, ok := rr.(*dns.A); ok {
+				servers = append(servers, net.JoinHostPort(a.A.String(), r.authPort))
+			}
+		}
+
+		if len(servers) > 0 {
+			return servers, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no addresses of nameservers for %s", trimDot(zone))
+}
+
+// followChain walks CNAMEs of the answer from the name and returns the last
+// name of the chain and whether records of the type are there.
+func followChain(answer []dns.RR, name string, qtype uint16) (string, bool) {
+	target := name
+
+	for range len(answer) + 1 {
+		next := ""
+		for _, rr := range answer {
+			if !strings.EqualFold(rr.Header().Name, target) {
+				continue
+			}
+
+			if rr.Header().Rrtype == qtype || qtype == dns.TypeANY {
+				return target, true
+			}
+
+			if cname, ok := rr.(*dns.CNAME); ok {
+				next = cname.Target
+			}
+		}
+
+		if next == "" {
+			break
+		}
+		target = next
+	}
+
+	return target, false
+}
@@ -0,0 +1,223 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// authHandler answers authoritatively for the zone of the SOA record among
+// the records and refers queries for names below NS records of other owners.
+func authHandler(t *testing.T, records ...string) dns.HandlerFunc {
+	t.Helper()
+
+	zone := make([]dns.RR, 0, len(records))
+	apex := ""
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		require.Nil(t, err)
+		zone = append(zone, rr)
+
+		if rr.Header().Rrtype == dns.TypeSOA {
+			apex = rr.Header().Name
+		}
+	}
+
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(req)
+
+		question := req.Question[0]
+
+		for _, rr := range zone {
+			ns, ok := rr.(*dns.NS)
+			if !ok || ns.Hdr.Name == apex || !dns.IsSubDomain(ns.Hdr.Name, question.Name) {
+				continue
+			}
+
+			msg.Ns = append(msg.Ns, ns)
+			for _, glue := range zone {
+				if _, ok := glue.(*dns.A); ok && glue.Header().Name == ns.Ns {
+					msg.Extra = append(msg.Extra, glue)
+				}
+			}
+		}
+
+		if len(msg.Ns) > 0 {
+			_ = w.WriteMsg(msg)
+			return
+		}
+
+		msg.Authoritative = true
+		exists := false
+		for _, rr := range zone {
+			if !strings.EqualFold(rr.Header().Name, question.Name) {
+				continue
+			}
+
+			exists = true
+			if rr.Header().Rrtype == question.Qtype || rr.Header().Rrtype == dns.TypeCNAME {
+				msg.Answer = append(msg.Answer, rr)
+			}
+		}
+
+		if len(msg.Answer) == 0 {
+			if !exists {
+				msg.Rcode = dns.RcodeNameError
+			}
+			for _, rr := range zone {
+				if rr.Header().Rrtype == dns.TypeSOA {
+					msg.Ns = append(msg.Ns, rr)
+				}
+			}
+		}
+
+		_ = w.WriteMsg(msg)
+	}
+}
+
+// startHierarchy starts servers on the same port of the given loopback
+// addresses, as referrals carry addresses of nameservers only.
+func startHierarchy(t *testing.T, handlers map[string]dns.Handler) string {
+	t.Helper()
+
+	for range 10 {
+		conns := make([]net.PacketConn, 0, len(handlers))
+		port := "0"
+
+		for address := range handlers {
+			conn, err := net.ListenPacket("udp", net.JoinHostPort(address, port))
+			if err != nil {
+				break
+			}
+
+			conns = append(conns, conn)
+			_, port, _ = net.SplitHostPort(conn.LocalAddr().String())
+		}
+
+		if len(conns) < len(handlers) {
+			for _, conn := range conns {
+				_ = conn.Close()
+			}
+			continue
+		}
+
+		for _, conn := range conns {
+			host, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+			serve(t, &dns.Server{PacketConn: conn, Handler: handlers[host]})
+		}
+
+		return port
+	}
+
+	t.Fatal("no free port on all of the loopback addresses")
+	return ""
+}
+
+func TestIterative(t *testing.T) {
+	port := startHierarchy(t, map[string]dns.Handler{
+		"127.0.0.1": authHandler(t,
+			". 60 IN SOA a.root. hostmaster.root. 1 7200 3600 1209600 300",
+			"example. 60 IN NS ns.example.",
+			"ns.example. 60 IN A 127.0.0.2",
+			"test. 60 IN NS ns-test.example.",
+			"ns-test.example. 60 IN A 127.0.0.5",
+		),
+		"127.0.0.2": authHandler(t,
+			"example. 60 IN SOA ns.example. hostmaster.example. 1 7200 3600 1209600 300",
+			"api.example. 60 IN A 10.0.0.1",
+			"www.example. 60 IN CNAME www.cdn.test.",
+			"ns-test.example. 60 IN A 127.0.0.4",
+			"sub.example. 60 IN NS ns1.sub.example.",
+			"ns1.sub.example. 60 IN A 127.0.0.3",
+		),
+		"127.0.0.3": authHandler(t,
+			"sub.example. 60 IN SOA ns1.sub.example. hostmaster.example. 1 7200 3600 1209600 300",
+			"host.sub.example. 60 IN A 10.0.0.2",
+		),
+		"127.0.0.4": authHandler(t,
+			"test. 60 IN SOA ns-test.example. hostmaster.test. 1 7200 3600 1209600 300",
+			"www.cdn.test. 60 IN A 10.0.0.3",
+		),
+		// The root refers test. with glue for ns-test.example., a name out
+		// of the zone it has no authority over, pointing here.
+		"127.0.0.5": authHandler(t,
+			"test. 60 IN SOA ns-test.example. hostmaster.test. 1 7200 3600 1209600 300",
+			"www.cdn.test. 60 IN A 10.6.6.6",
+		),
+	})
+
+	r := NewResolver().
+		WithIterative(true).
+		WithRootHints([]string{net.JoinHostPort("127.0.0.1", port)}).
+		WithSearch(false)
+	r.authPort = port
+
+	response, err := r.Resolve(context.Background(), []string{"api.example", "host.sub.example", "www.example", "missing.example"})
+	require.Nil(t, err)
+
+	require.Equal(t, []Response{
+		{
+			Name:      "api.example",
+			Addresses: []string{"10.0.0.1"},
+			Rcode:     "NOERROR",
+			TTLMin:    uint32Ptr(60),
+			TTLMax:    uint32Ptr(60),
+			Server:    net.JoinHostPort("127.0.0.2", port),
+		},
+		{
+			Name:      "host.sub.example",
+			Addresses: []string{"10.0.0.2"},
+			Rcode:     "NOERROR",
+			TTLMin:    uint32Ptr(60),
+			TTLMax:    uint32Ptr(60),
+			Server:    net.JoinHostPort("127.0.0.3", port),
+		},
+		{
+			Name:      "www.example",
+			Addresses: []string{"10.0.0.3"},
+			CNAMEs:    []string{"www.cdn.test"},
+			Rcode:     "NOERROR",
+			TTLMin:    uint32Ptr(60),
+			TTLMax:    uint32Ptr(60),
+			Server:    net.JoinHostPort("127.0.0.4", port),
+		},
+		{
+			Name:      "missing.example",
+			Addresses: []string{},
+			Rcode:     "NXDOMAIN",
+			Server:    net.JoinHostPort("127.0.0.2", port),
+		},
+	}, stripTiming(response))
+
+	_, err = r.WithMaxDepth(3).Resolve(context.Background(), []string{"www.example"})
+	require.ErrorIs(t, err, errMaxDepth)
+}
+
+func TestFollowChain(t *testing.T) {
+	answer := make([]dns.RR, 0)
+	for _, record := range []string{
+		"www.example. 60 IN CNAME edge.example.",
+		"edge.example. 60 IN CNAME www.example.",
+	} {
+		rr, err := dns.NewRR(record)
+		require.Nil(t, err)
+		answer = append(answer, rr)
+	}
+
+	target, answered := followChain(answer, "www.example.", dns.TypeA)
+	require.False(t, answered)
+	require.Equal(t, "edge.example.", target)
+
+	target, answered = followChain(answer, "www.example.", dns.TypeCNAME)
+	require.True(t, answered)
+	require.Equal(t, "www.example.", target)
+
+	target, answered = followChain(answer, "WWW.Example.", dns.TypeANY)
+	require.True(t, answered)
+	require.Equal(t, "WWW.Example.", target)
+}
@@ -0,0 +1,89 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	RateLimitDefault = 0
+	RateBurstDefault = 1
+)
+
+// limiter is a token bucket refilled with rate tokens per second up to burst
+// tokens. Tokens may go below zero: every caller reserves one and waits until
+// the bucket refills up to its reservation, so waiting callers are served in
+// the order they came.
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	return &limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token and returns how long to wait before using it.
+func (l *limiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.After(l.last) {
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+	}
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// WithRateLimit caps queries to every upstream server at rate per second with
+// bursts of up to burst queries; queries over the limit wait for their turn.
+// Zero rate means no limit.
+func (r *Resolver) WithRateLimit(rate float64, burst int) *Resolver {
+	r.limitersMu.Lock()
+	defer r.limitersMu.Unlock()
+
+	r.rateLimit = max(rate, 0)
+	r.rateBurst = max(burst, 1)
+	r.limiters = make(map[string]*limiter)
+	return r
+}
+
+// throttle blocks until the rate limit of the server lets the query go and
+// returns the time it waited.
+func (r *Resolver) throttle(ctx context.Context, server string) (time.Duration, error) {
+	if r.rateLimit == 0 {
+		return 0, nil
+	}
+
+	r.limitersMu.Lock()
+	l, ok := r.limiters[server]
+	if !ok {
+		l = newLimiter(r.rateLimit, r.rateBurst)
+		r.limiters[server] = l
+	}
+	r.limitersMu.Unlock()
+
+	wait := l.reserve(time.Now())
+	if wait > 0 {
+		if err := sleep(ctx, wait); err != nil {
+			return 0, err
+		}
+	}
+
+	return wait, nil
+}
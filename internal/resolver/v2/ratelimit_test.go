@@ -0,0 +1,63 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := newLimiter(10, 2)
+	l.last = now
+
+	require.Equal(t, time.Duration(0), l.reserve(now))
+	require.Equal(t, time.Duration(0), l.reserve(now))
+	require.Equal(t, 100*time.Millisecond, l.reserve(now))
+	require.Equal(t, 200*time.Millisecond, l.reserve(now))
+
+	now = now.Add(300 * time.Millisecond)
+	require.Equal(t, time.Duration(0), l.reserve(now))
+	require.Equal(t, 100*time.Millisecond, l.reserve(now))
+
+	now = now.Add(time.Hour)
+	require.Equal(t, time.Duration(0), l.reserve(now))
+	require.Equal(t, time.Duration(0), l.reserve(now))
+	require.Equal(t, 100*time.Millisecond, l.reserve(now))
+}
+
+func TestRateLimit(t *testing.T) {
+	server := startServer(t, zoneHandler(t, "www.example. 60 IN A 10.0.0.1"))
+	silent := startSilentServer(t)
+
+	names := []string{"www.example", "www.example", "www.example", "www.example", "www.example"}
+
+	r := NewResolver().
+		WithServers([]string{server}).
+		WithConcurrency(len(names)).
+		WithRateLimit(20, 1)
+
+	start := time.Now()
+	_, err := r.Resolve(context.Background(), names)
+	require.Nil(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+	stats := r.Stats()
+	require.Equal(t, 5, stats[server].Queries)
+	require.Equal(t, 0, stats[server].Failures)
+	require.GreaterOrEqual(t, stats[server].Throttled, 400*time.Millisecond)
+
+	r = NewResolver().
+		WithServers([]string{silent, server}).
+		WithAttempts(1).
+		WithTimeout(100 * time.Millisecond)
+
+	_, err = r.Resolve(context.Background(), names[:2])
+	require.Nil(t, err)
+
+	stats = r.Stats()
+	require.Equal(t, UpstreamStats{Queries: 2, Failures: 2}, stats[silent])
+	require.Equal(t, UpstreamStats{Queries: 2}, stats[server])
+}
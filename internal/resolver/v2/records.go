@@ -0,0 +1,113 @@
+package resolver
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/pabateman/dns-lookuper/internal/resolver"
+)
+
+type (
+	MX     = resolver.MX
+	SRV    = resolver.SRV
+	CAA    = resolver.CAA
+	SOA    = resolver.SOA
+	Record = resolver.Record
+)
+
+// ParseType returns the record type for its case-insensitive name.
+func ParseType(t string) (uint16, bool) {
+	qtype, ok := dns.StringToType[strings.ToUpper(t)]
+	return qtype, ok
+}
+
+// addAddress adds the address of an A or AAAA record; an address already
+// added from an SVCB hint is kept in place but no longer marked as a hint.
+func addAddress(r *Response, address string) {
+	if _, ok := r.Provenance[address]; ok {
+		delete(r.Provenance, address)
+		return
+	}
+
+	r.Addresses = append(r.Addresses, address)
+}
+
+// addAnswer sorts records of the answer section into typed fields; CNAMEs
+// form the chain the name resolved through.
+func addAnswer(r *Response, answer []dns.RR) {
+	for _, rr := range answer {
+		ttl := rr.Header().Ttl
+		if r.TTLMin == nil || ttl < *r.TTLMin {
+			r.TTLMin = &ttl
+		}
+		if r.TTLMax == nil || ttl > *r.TTLMax {
+			r.TTLMax = &ttl
+		}
+
+		switch rr := rr.(type) {
+		case *dns.A:
+			addAddress(r, rr.A.String())
+		case *dns.AAAA:
+			addAddress(r, rr.AAAA.String())
+		case *dns.CNAME:
+			target := trimDot(rr.Target)
+			if !slices.Contains(r.CNAMEs, target) {
+				r.CNAMEs = append(r.CNAMEs, target)
+			}
+		case *dns.MX:
+			r.MX = append(r.MX, MX{
+				Priority: rr.Preference,
+				Target:   trimDot(rr.Mx),
+			})
+		case *dns.SRV:
+			r.SRV = append(r.SRV, SRV{
+				Priority: rr.Priority,
+				Weight:   rr.Weight,
+				Port:     rr.Port,
+				Target:   trimDot(rr.Target),
+			})
+		case *dns.TXT:
+			r.TXT = append(r.TXT, strings.Join(rr.Txt, ""))
+		case *dns.NS:
+			r.NS = append(r.NS, trimDot(rr.Ns))
+		case *dns.CAA:
+			r.CAA = append(r.CAA, CAA{
+				Flag:  rr.Flag,
+				Tag:   rr.Tag,
+				Value: rr.Value,
+			})
+		case *dns.SOA:
+			r.SOA = &SOA{
+				Mname:   trimDot(rr.Ns),
+				Rname:   trimDot(rr.Mbox),
+				Serial:  rr.Serial,
+				Refresh: rr.Refresh,
+				Retry:   rr.Retry,
+				Expire:  rr.Expire,
+				Minttl:  rr.Minttl,
+			}
+		case *dns.PTR:
+			r.PTR = append(r.PTR, trimDot(rr.Ptr))
+		case *dns.SVCB:
+			addSVCB(r, dns.TypeToString[dns.TypeSVCB], rr)
+		case *dns.HTTPS:
+			addSVCB(r, dns.TypeToString[dns.TypeHTTPS], &rr.SVCB)
+		default:
+			r.Records = append(r.Records, Record{
+				Type:  dns.TypeToString[rr.Header().Rrtype],
+				Value: strings.TrimPrefix(rr.String(), rr.Header().String()),
+			})
+		}
+	}
+}
+
+// trimDot drops the trailing dot of a fully qualified name except the root.
+func trimDot(name string) string {
+	if name == "." {
+		return name
+	}
+
+	return strings.TrimSuffix(name, ".")
+}
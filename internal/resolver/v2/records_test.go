@@ -0,0 +1,150 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordTypes(t *testing.T) {
+	server := startServer(t, zoneHandler(t,
+		"example. 60 IN MX 10 mx1.example.",
+		"example. 60 IN MX 20 mx2.example.",
+		"example. 60 IN TXT \"v=spf1 \" \"-all\"",
+		"example. 60 IN NS ns1.example.",
+		"example. 60 IN CAA 0 issue \"letsencrypt.org\"",
+		"example. 60 IN SOA ns1.example. hostmaster.example. 2024010101 7200 3600 1209600 300",
+		"example. 60 IN HINFO \"amd64\" \"linux\"",
+		"_sip._tcp.example. 60 IN SRV 10 60 5060 sip.example.",
+		"1.0.0.10.in-addr.arpa. 60 IN PTR host.example.",
+	))
+
+	r := NewResolver().
+		WithServers([]string{server}).
+		WithTypes([]string{"mx", "TXT", "ns", "caa", "soa", "hinfo", "unknown"})
+
+	response, err := r.Resolve(context.Background(), []string{"example"})
+	require.Nil(t, err)
+
+	require.Equal(t, []Response{
+		{
+			Name:      "example",
+			Addresses: []string{},
+			MX: []MX{
+				{Priority: 10, Target: "mx1.example"},
+				{Priority: 20, Target: "mx2.example"},
+			},
+			TXT: []string{"v=spf1 -all"},
+			NS:  []string{"ns1.example"},
+			CAA: []CAA{
+				{Flag: 0, Tag: "issue", Value: "letsencrypt.org"},
+			},
+			SOA: &SOA{
+				Mname:   "ns1.example",
+				Rname:   "hostmaster.example",
+				Serial:  2024010101,
+				Refresh: 7200,
+				Retry:   3600,
+				Expire:  1209600,
+				Minttl:  300,
+			},
+			Records: []Record{
+				{Type: "HINFO", Value: "\"amd64\" \"linux\""},
+			},
+			Server: server,
+			Rcode:  "NOERROR",
+			TTLMin: uint32Ptr(60),
+			TTLMax: uint32Ptr(60),
+		},
+	}, stripTiming(response))
+
+	response, err = r.WithMode("srv").WithTypes(nil).Resolve(context.Background(), []string{"_sip._tcp.example"})
+	require.Nil(t, err)
+	require.Equal(t, []SRV{{Priority: 10, Weight: 60, Port: 5060, Target: "sip.example"}}, response[0].SRV)
+
+	response, err = r.WithMode("PTR").Resolve(context.Background(), []string{"1.0.0.10.in-addr.arpa"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"host.example"}, response[0].PTR)
+}
+
+func TestRecordTTL(t *testing.T) {
+	server := startServer(t, zoneHandler(t,
+		"example. 0 IN TXT \"uncached\"",
+		"example. 60 IN NS ns1.example.",
+	))
+
+	r := NewResolver().
+		WithServers([]string{server}).
+		WithTypes([]string{"TXT", "NS"})
+
+	response, err := r.Resolve(context.Background(), []string{"example"})
+	require.Nil(t, err)
+	require.Equal(t, uint32Ptr(0), response[0].TTLMin)
+	require.Equal(t, uint32Ptr(60), response[0].TTLMax)
+
+	// A TTL of 0 is printed like any other.
+	encoded, err := json.Marshal(response[0])
+	require.Nil(t, err)
+	require.Contains(t, string(encoded), `"ttlMin":0,"ttlMax":60`)
+}
+
+func TestParseType(t *testing.T) {
+	qtype, ok := ParseType("mx")
+	require.True(t, ok)
+	require.Equal(t, dns.TypeMX, qtype)
+
+	qtype, ok = ParseType("AAAA")
+	require.True(t, ok)
+	require.Equal(t, dns.TypeAAAA, qtype)
+
+	_, ok = ParseType("foobarbuzz")
+	require.False(t, ok)
+
+	require.Equal(t, []uint16{dns.TypeCAA}, getQueryTypes("caa"))
+	require.Equal(t, []uint16{dns.TypeA}, getQueryTypes("foobarbuzz"))
+}
+
+func TestReverse(t *testing.T) {
+	server := startServer(t, zoneHandler(t,
+		"1.0.0.10.in-addr.arpa. 60 IN PTR host.example.",
+		"1.0.0.10.in-addr.arpa. 60 IN PTR alias.example.",
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa. 60 IN PTR host6.example.",
+	))
+
+	r := NewResolver().
+		WithServers([]string{server}).
+		WithMode(ModePTR)
+
+	response, err := r.Resolve(context.Background(), []string{"10.0.0.1", "fd00::1", "10.0.0.2"})
+	require.Nil(t, err)
+
+	require.Equal(t, []Response{
+		{
+			Name:      "10.0.0.1",
+			Addresses: []string{},
+			PTR:       []string{"host.example", "alias.example"},
+			Server:    server,
+			Rcode:     "NOERROR",
+			TTLMin:    uint32Ptr(60),
+			TTLMax:    uint32Ptr(60),
+		},
+		{
+			Name:      "fd00::1",
+			Addresses: []string{},
+			PTR:       []string{"host6.example"},
+			Server:    server,
+			Rcode:     "NOERROR",
+			TTLMin:    uint32Ptr(60),
+			TTLMax:    uint32Ptr(60),
+		},
+		{
+			Name:      "10.0.0.2",
+			Addresses: []string{},
+			Server:    server,
+			Rcode:     "NXDOMAIN",
+		},
+	}, stripTiming(response))
+}
@@ -1,131 +1,468 @@
 package resolver
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"iter"
+	"net/netip"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/miekg/dns"
+
+	"github.com/pabateman/dns-lookuper/internal/resolver"
 )
 
 const (
 	ModeIpv4    = "ipv4"
 	ModeIpv6    = "ipv6"
+	ModeAll     = "all"
+	ModePTR     = "ptr"
 	ModeDefault = ModeIpv4
 )
 
 const (
-	TimeoutDefault = time.Duration(15 * time.Second)
+	TimeoutDefault     = time.Duration(15 * time.Second)
+	ConcurrencyDefault = 1
+	MaxInflightDefault = 0
+	AttemptsDefault    = 2
+	BackoffDefault     = time.Duration(0)
 )
 
-type Response struct {
-	Name      string   `json:"name"`
-	Addresses []string `json:"addresses"`
-	rcode     int
-}
+// Response and its records are shared by all backends.
+type Response = resolver.Response
+
+type Duration = resolver.Duration
 
 type Resolver struct {
-	resolver *dns.Client
-	timeout  time.Duration
-	mode     uint16
+	timeout     time.Duration
+	mode        []uint16
+	concurrency int
+	maxInflight int
+	inflight    map[string]chan struct{}
+	inflightMu  sync.Mutex
+	servers     []string
+	resolvConf  string
+	attempts    int
+	rotate      bool
+	backoff     time.Duration
+	transport   string
+	tlsConfig   *tls.Config
+	dohMethod   string
+	dohHeaders  map[string]string
+	search      bool
+	cache       *Cache
+	rateLimit   float64
+	rateBurst   int
+	limiters    map[string]*limiter
+	limitersMu  sync.Mutex
+	stats       map[string]*UpstreamStats
+	statsMu     sync.Mutex
+	iterative   bool
+	rootHints   []string
+	maxDepth    int
+	authPort    string
+	wildcard    bool
+	subnet      netip.Prefix
+
+	dnssec       string
+	trustAnchors []dns.RR
+
+	samples        int
+	sampleInterval time.Duration
+	sampleServers  bool
 }
 
 func NewResolver() *Resolver {
 	return &Resolver{
-		resolver: &dns.Client{
-			SingleInflight: true,
-			Timeout:        TimeoutDefault,
-		},
-		timeout: TimeoutDefault,
-		mode:    getQueryTypes(ModeDefault),
+		timeout:     0,
+		mode:        getQueryTypes(ModeDefault),
+		concurrency: ConcurrencyDefault,
+		maxInflight: MaxInflightDefault,
+		inflight:    make(map[string]chan struct{}),
+		servers:     make([]string, 0),
+		resolvConf:  ResolvConfPath,
+		attempts:    0,
+		rotate:      false,
+		backoff:     BackoffDefault,
+		transport:   TransportDefault,
+		dohMethod:   DoHMethodDefault,
+		dohHeaders:  make(map[string]string),
+		search:      true,
+		rateLimit:   RateLimitDefault,
+		rateBurst:   RateBurstDefault,
+		limiters:    make(map[string]*limiter),
+		stats:       make(map[string]*UpstreamStats),
+		iterative:   false,
+		rootHints:   RootHintsDefault,
+		maxDepth:    MaxDepthDefault,
+		authPort:    PortDefault,
+		wildcard:    false,
+		samples:     1,
+		dnssec:      DNSSECDefault,
 	}
 }
 
+// WithTimeout sets the timeout of a single query; the timeout option of
+// resolv.conf or TimeoutDefault is used when it is not set.
 func (r *Resolver) WithTimeout(t time.Duration) *Resolver {
-	r.resolver.Timeout = t
+	r.timeout = t
+	return r
+}
+
+// WithAttempts sets how many times every server is tried; the attempts option
+// of resolv.conf or AttemptsDefault is used when it is not set.
+func (r *Resolver) WithAttempts(a int) *Resolver {
+	r.attempts = max(a, 0)
+	return r
+}
+
+// WithRotate spreads queries over servers in round robin order instead of
+// always starting from the first one, like the rotate option of resolv.conf.
+func (r *Resolver) WithRotate(rotate bool) *Resolver {
+	r.rotate = rotate
+	return r
+}
+
+// WithBackoff sets the pause before the second pass over servers, doubled on
+// every next pass.
+func (r *Resolver) WithBackoff(b time.Duration) *Resolver {
+	r.backoff = max(b, 0)
 	return r
 }
 
+// WithMode accepts one of the address modes or a record type name like "mx".
 func (r *Resolver) WithMode(m string) *Resolver {
 	r.mode = getQueryTypes(m)
 	return r
 }
 
-func (r *Resolver) Resolve(dn []string) ([]Response, error) {
-	result := make([]Response, 0)
-	config, err := dns.ClientConfigFromFile("/etc/resolv.conf")
-	if err != nil {
-		return nil, err
+// WithTypes overrides the mode with the list of record types to query; names
+// unknown to ParseType are skipped.
+func (r *Resolver) WithTypes(types []string) *Resolver {
+	qtypes := make([]uint16, 0, len(types))
+	for _, t := range types {
+		if qtype, ok := ParseType(t); ok && !slices.Contains(qtypes, qtype) {
+			qtypes = append(qtypes, qtype)
+		}
 	}
 
-	for _, name := range dn {
-		result = append(result, Response{
-			Name:      name,
-			Addresses: make([]string, 0),
-		},
-		)
+	if len(qtypes) > 0 {
+		r.mode = qtypes
+	}
+	return r
+}
 
-		msgQuery := &dns.Msg{
-			MsgHdr: dns.MsgHdr{
-				Id:               dns.Id(),
-				RecursionDesired: true,
-			},
-			Question: []dns.Question{
-				{
-					Name:   dns.Fqdn(name),
-					Qtype:  r.mode,
-					Qclass: dns.ClassINET,
-				},
-			},
+func (r *Resolver) WithConcurrency(c int) *Resolver {
+	r.concurrency = max(c, 1)
+	return r
+}
+
+func (r *Resolver) WithMaxInflight(m int) *Resolver {
+	r.inflightMu.Lock()
+	defer r.inflightMu.Unlock()
+
+	r.maxInflight = max(m, 0)
+	r.inflight = make(map[string]chan struct{})
+	return r
+}
+
+// WithServers sets upstream servers in "host:port" form as returned by
+// ParseServer; nameservers from resolv.conf are used when the list is empty.
+func (r *Resolver) WithServers(s []string) *Resolver {
+	r.servers = s
+	return r
+}
+
+// WithTransport sets the protocol of queries; truncated UDP responses are
+// always repeated over TCP.
+func (r *Resolver) WithTransport(t string) *Resolver {
+	r.transport = t
+	return r
+}
+
+// WithSearch toggles expansion of names with the search list of resolv.conf.
+func (r *Resolver) WithSearch(s bool) *Resolver {
+	r.search = s
+	return r
+}
+
+// WithCache makes the resolver answer from the cache while responses are fresh;
+// nil disables caching.
+func (r *Resolver) WithCache(c *Cache) *Resolver {
+	r.cache = c
+	return r
+}
+
+// Responses keep the order of dn; the first error stops scheduling of the
+// remaining names. Cancellation of ctx aborts queries in flight and makes
+// Resolve return the error of ctx.
+func (r *Resolver) Resolve(ctx context.Context, dn []string) ([]Response, error) {
+	return resolver.Collect(r.ResolveStream(ctx, slices.Values(dn)))
+}
+
+// ResolveStream yields responses in the order of dn as soon as they and all
+// responses before them are ready; no more than twice the concurrency of
+// responses are held at once however long dn is. Iteration stops after the
+// first error, the error of ctx once it is done.
+func (r *Resolver) ResolveStream(ctx context.Context, dn iter.Seq[string]) iter.Seq2[Response, error] {
+	return func(yield func(Response, error) bool) {
+		u, err := r.upstreams()
+		if err != nil {
+			yield(Response{}, err)
+			return
+		}
+		defer u.close()
+
+		type result struct {
+			response Response
+			err      error
+		}
+
+		type job struct {
+			name   string
+			result chan<- result
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		defer func() {
+			cancel()
+			wg.Wait()
+		}()
+
+		jobs := make(chan job)
+		pending := make(chan chan result, r.concurrency)
+
+		for range r.concurrency {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := range jobs {
+					response, err := r.resolveName(ctx, j.name, u)
+					j.result <- result{response, err}
+				}
+			}()
 		}
 
-		msqResponse, _, err := r.resolver.Exchange(
-			msgQuery,
-			fmt.Sprintf("%s:%s", config.Servers[0], "53"),
-		)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(pending)
+			defer close(jobs)
+
+			for name := range dn {
+				ch := make(chan result, 1)
+
+				select {
+				case jobs <- job{name, ch}:
+				case <-ctx.Done():
+					return
+				}
+
+				select {
+				case pending <- ch:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		for ch := range pending {
+			result := <-ch
+			if result.err != nil {
+				yield(Response{}, result.err)
+				return
+			}
+
+			if !yield(result.response, nil) {
+				return
+			}
+		}
 
+		if err := ctx.Err(); err != nil {
+			yield(Response{}, err)
+		}
+	}
+}
+
+// resolveName tries names of the search list until one of them has an
+// answer; the response for the name as is gets reported otherwise.
+func (r *Resolver) resolveName(ctx context.Context, name string, u *upstreams) (Response, error) {
+	var result Response
+
+	for _, fqdn := range u.nameList(name) {
+		response, answered, err := r.resolveFQDN(ctx, name, fqdn, u)
 		if err != nil {
-			return nil, err
+			return response, err
 		}
 
-		result[len(result)-1].rcode = msqResponse.MsgHdr.Rcode
+		if answered {
+			err = r.checkWildcard(ctx, u, &response, fqdn)
+			if err != nil {
+				return response, err
+			}
 
-		for _, response := range msqResponse.Answer {
-			result[len(result)-1].Addresses = append(result[len(result)-1].Addresses, strings.Split(response.String(), "\t")[4])
+			return r.sample(ctx, u, response, fqdn)
 		}
 
+		if fqdn == dns.Fqdn(name) {
+			result = response
+		}
 	}
 
 	return result, nil
 }
 
-func FilterResponsesByRcode(rs []Response, rcode int) []Response {
-	result := make([]Response, 0)
+// resolveFQDN sends a query per type of the mode and merges answers into
+// one response. When some of the queries fail while others succeed, the
+// response is marked as partial and keeps the failures in Errors.
+func (r *Resolver) resolveFQDN(ctx context.Context, name string, fqdn string, u *upstreams) (Response, bool, error) {
+	result := Response{
+		Name:      name,
+		Addresses: make([]string, 0),
+	}
+
+	if fqdn != dns.Fqdn(name) {
+		result.FQDN = trimDot(fqdn)
+	}
+
+	if r.subnet.IsValid() {
+		result.Subnet = r.subnet.String()
+	}
+
+	type answer struct {
+		qtype uint16
+		reply *reply
+		err   error
+	}
 
-	for _, r := range rs {
-		if r.rcode == rcode {
-			result = append(result, r)
+	answers := make([]answer, 0, len(r.mode))
+	var succeeded *reply
+
+	for _, qtype := range r.mode {
+		query := newQuery(fqdn, qtype)
+		r.setClientSubnet(query)
+		r.setDNSSEC(query)
+
+		reply, err := r.exchange(ctx, u, query)
+		if err == nil && r.dnssec == DNSSECRequire && validated(reply.msg.Rcode) {
+			err = r.validate(ctx, u, reply.msg)
+			if ctx.Err() != nil {
+				return result, false, ctx.Err()
+			}
+
+			if err != nil {
+				result.DNSSEC = dnssecStatus(err)
+			}
+		}
+		answers = append(answers, answer{qtype, reply, err})
+
+		if err != nil {
+			continue
+		}
+
+		if succeeded == nil || (succeeded.msg.Rcode != dns.RcodeSuccess && reply.msg.Rcode == dns.RcodeSuccess) {
+			succeeded = reply
 		}
 	}
 
-	return result
-}
+	if succeeded == nil {
+		if result.DNSSEC == "" {
+			return result, false, answers[0].err
+		}
 
-func FilterResponsesNoerror(rs []Response) []Response {
-	return FilterResponsesByRcode(rs, dns.StringToRcode["NOERROR"])
+		// Answers failing validation are reported the way validating
+		// resolvers do, as SERVFAIL.
+		result.Rcode = dns.RcodeToString[dns.RcodeServerFailure]
+		for _, a := range answers {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %+v", dns.TypeToString[a.qtype], a.err))
+		}
+
+		return result, false, nil
+	}
+
+	rcode := succeeded.msg.Rcode
+	timestamp := succeeded.timestamp
+
+	result.Rcode = dns.RcodeToString[rcode]
+	result.Server = succeeded.server
+	result.Timestamp = &timestamp
+	result.Cached = true
+	result.AD = r.dnssec == DNSSECReport
+
+	answered := false
+	for _, a := range answers {
+		switch {
+		case a.err != nil:
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %+v", dns.TypeToString[a.qtype], a.err))
+		case a.reply.msg.Rcode != rcode:
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %s", dns.TypeToString[a.qtype], dns.RcodeToString[a.reply.msg.Rcode]))
+		default:
+			addAnswer(&result, stripSignatures(a.reply.msg.Answer, a.qtype))
+			result.AD = result.AD && a.reply.msg.AuthenticatedData
+			result.RTT = max(result.RTT, Duration(a.reply.rtt))
+			result.Cached = result.Cached && a.reply.cached
+
+			err := r.followAliases(ctx, u, &result, a.qtype, a.reply.msg.Answer)
+			if err != nil {
+				return result, false, err
+			}
+			answered = answered || (rcode == dns.RcodeSuccess && len(a.reply.msg.Answer) > 0)
+		}
+	}
+
+	result.Partial = len(result.Errors) > 0 && rcode == dns.RcodeSuccess
+
+	if r.dnssec == DNSSECRequire && result.DNSSEC == "" {
+		result.DNSSEC = DNSSECSecure
+	}
+
+	return result, answered, nil
 }
 
-func FilterResponsesNxdomain(rs []Response) []Response {
-	return FilterResponsesByRcode(rs, dns.StringToRcode["NXDOMAIN"])
+// newQuery turns IP addresses into in-addr.arpa and ip6.arpa names for PTR
+// queries.
+func newQuery(name string, qtype uint16) *dns.Msg {
+	qname := dns.Fqdn(name)
+	if qtype == dns.TypePTR {
+		if reverse, err := dns.ReverseAddr(strings.TrimSuffix(name, ".")); err == nil {
+			qname = reverse
+		}
+	}
+
+	return &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Id:               dns.Id(),
+			RecursionDesired: true,
+		},
+		Question: []dns.Question{
+			{
+				Name:   qname,
+				Qtype:  qtype,
+				Qclass: dns.ClassINET,
+			},
+		},
+	}
 }
 
-func getQueryTypes(m string) uint16 {
+func getQueryTypes(m string) []uint16 {
 	switch m {
 	case ModeIpv4:
-		return dns.TypeA
+		return []uint16{dns.TypeA}
 	case ModeIpv6:
-		return dns.TypeAAAA
+		return []uint16{dns.TypeAAAA}
+	case ModeAll:
+		return []uint16{dns.TypeA, dns.TypeAAAA}
+	case ModePTR:
+		return []uint16{dns.TypePTR}
 	default:
-		return dns.TypeA
+		if qtype, ok := ParseType(m); ok {
+			return []uint16{qtype}
+		}
+		return []uint16{dns.TypeA}
 	}
 }
@@ -1,12 +1,20 @@
 package resolver
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
 	"slices"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/require"
+
+	"github.com/pabateman/dns-lookuper/internal/resolver"
 )
 
 var (
@@ -29,12 +37,12 @@ var (
 		{
 			Name:      "iana.org",
 			Addresses: []string{"192.0.43.8"},
-			rcode:     0,
+			Rcode:     "NOERROR",
 		},
 		{
 			Name:      "kernel.org",
 			Addresses: []string{"139.178.84.217"},
-			rcode:     0,
+			Rcode:     "NOERROR",
 		},
 	}
 
@@ -42,12 +50,12 @@ var (
 		{
 			Name:      "iana.org",
 			Addresses: []string{"2001:500:88:200::8"},
-			rcode:     0,
+			Rcode:     "NOERROR",
 		},
 		{
 			Name:      "kernel.org",
 			Addresses: []string{"2604:1380:4641:c500::1"},
-			rcode:     0,
+			Rcode:     "NOERROR",
 		},
 	}
 
@@ -55,12 +63,12 @@ var (
 		{
 			Name:      "fedora.com",
 			Addresses: []string{"86.105.245.69"},
-			rcode:     0,
+			Rcode:     "NOERROR",
 		},
 		{
 			Name:      "hashicorp.com",
 			Addresses: []string{"76.76.21.21"},
-			rcode:     0,
+			Rcode:     "NOERROR",
 		},
 	}
 
@@ -68,12 +76,12 @@ var (
 		{
 			Name:      "fedora.com",
 			Addresses: []string{},
-			rcode:     0,
+			Rcode:     "NOERROR",
 		},
 		{
 			Name:      "hashicorp.com",
 			Addresses: []string{},
-			rcode:     0,
+			Rcode:     "NOERROR",
 		},
 	}
 
@@ -81,78 +89,420 @@ var (
 		{
 			Name:      "foo.iana.org",
 			Addresses: []string{},
-			rcode:     3,
+			Rcode:     "NXDOMAIN",
 		},
 		{
 			Name:      "buz.kernel.org",
 			Addresses: []string{},
-			rcode:     3,
+			Rcode:     "NXDOMAIN",
 		},
 	}
 )
 
+// stripTiming clears fields which differ from run to run.
+func stripTiming(rs []Response) []Response {
+	for i := range rs {
+		rs[i].RTT = 0
+		rs[i].Timestamp = nil
+	}
+
+	return rs
+}
+
+// stripVolatile clears fields depending on the environment the tests run in.
+func stripVolatile(rs []Response) []Response {
+	for i := range stripTiming(rs) {
+		rs[i].Server = ""
+		rs[i].TTLMin = nil
+		rs[i].TTLMax = nil
+	}
+
+	return rs
+}
+
 func TestBasicResolver(t *testing.T) {
 	r := NewResolver()
-	response, err := r.Resolve(dnValid)
+	response, err := r.Resolve(context.Background(), dnValid)
 	require.Nil(t, err)
 
-	require.Equal(t, expectedValidIPv4, response)
+	require.Equal(t, expectedValidIPv4, stripVolatile(response))
 
 	r.WithMode(ModeIpv6)
-	response, err = r.Resolve(dnValid)
+	response, err = r.Resolve(context.Background(), dnValid)
 	require.Nil(t, err)
 
-	require.Equal(t, expectedValidIPv6, response)
+	require.Equal(t, expectedValidIPv6, stripVolatile(response))
 
 	r.WithMode(ModeIpv4)
-	response, err = r.Resolve(dnValid)
+	response, err = r.Resolve(context.Background(), dnValid)
 	require.Nil(t, err)
 
-	require.Equal(t, expectedValidIPv4, response)
+	require.Equal(t, expectedValidIPv4, stripVolatile(response))
 
 	r.WithMode("foobarbuzz")
-	require.Equal(t, r.mode, dns.TypeA)
+	require.Equal(t, r.mode, []uint16{dns.TypeA})
 }
 
 func TestOnlyIPv4(t *testing.T) {
 	r := NewResolver()
 
-	response, err := r.Resolve(dnOnlyIPv4)
+	response, err := r.Resolve(context.Background(), dnOnlyIPv4)
 	require.Nil(t, err)
 
-	require.Equal(t, expectedOnlyIPv4, response)
+	require.Equal(t, expectedOnlyIPv4, stripVolatile(response))
 
 	r.WithMode(ModeIpv6)
-	responseEmpty, err := r.Resolve(dnOnlyIPv4)
+	responseEmpty, err := r.Resolve(context.Background(), dnOnlyIPv4)
 	require.Nil(t, err)
 
-	require.Equal(t, expectedOnlyIPv4Empty, responseEmpty)
+	require.Equal(t, expectedOnlyIPv4Empty, stripVolatile(responseEmpty))
 }
 
 func TestNxdomain(t *testing.T) {
 	r := NewResolver()
 
-	responseNxdomain, err := r.Resolve(dnNxdomain)
+	responseNxdomain, err := r.Resolve(context.Background(), dnNxdomain)
 	require.Nil(t, err)
 
-	require.Equal(t, expectedNxdomain, responseNxdomain)
+	require.Equal(t, expectedNxdomain, stripVolatile(responseNxdomain))
 
-	responseValid, err := r.Resolve(dnValid)
+	responseValid, err := r.Resolve(context.Background(), dnValid)
 	require.Nil(t, err)
 
 	responseTotal := slices.Concat(responseNxdomain, responseValid)
 
-	responseTotal = FilterResponsesNoerror(responseTotal)
-	require.Equal(t, expectedValidIPv4, responseTotal)
+	responseTotal = resolver.FilterResponsesNoerror(responseTotal)
+	require.Equal(t, expectedValidIPv4, stripVolatile(responseTotal))
 
-	responseTotal = FilterResponsesNoerror(responseTotal)
-	require.Equal(t, expectedValidIPv4, responseTotal)
+	responseTotal = resolver.FilterResponsesNoerror(responseTotal)
+	require.Equal(t, expectedValidIPv4, stripVolatile(responseTotal))
 }
 
 func TestTimeout(t *testing.T) {
 	r := NewResolver().WithTimeout(time.Microsecond * 10)
 
-	_, err := r.Resolve([]string{dnValid[0]})
+	_, err := r.Resolve(context.Background(), []string{dnValid[0]})
 	require.NotNil(t, err)
 
 }
+
+func TestConcurrency(t *testing.T) {
+	names := make([]string, 0)
+	expected := make([][]string, 0)
+	for i := range 8 {
+		names = append(names, fmt.Sprintf("host%d.example", i))
+		expected = append(expected, []string{fmt.Sprintf("10.0.0.%d", i)})
+	}
+
+	// Earlier names are answered later, so responses come out of order and
+	// queries overlap for as long as the limits let them.
+	var active, peak atomic.Int32
+	server := startServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		current := active.Add(1)
+
+		for {
+			old := peak.Load()
+			if current <= old || peak.CompareAndSwap(old, current) {
+				break
+			}
+		}
+
+		var i int
+		_, err := fmt.Sscanf(req.Question[0].Name, "host%d.example.", &i)
+		require.Nil(t, err)
+		time.Sleep(time.Duration(len(names)-i) * 10 * time.Millisecond)
+
+		msg := new(dns.Msg)
+		msg.SetReply(req)
+		rr, err := dns.NewRR(fmt.Sprintf("%s 60 IN A 10.0.0.%d", req.Question[0].Name, i))
+		require.Nil(t, err)
+		msg.Answer = append(msg.Answer, rr)
+
+		// The reply frees the slot of the query, so the query is over first.
+		active.Add(-1)
+		_ = w.WriteMsg(msg)
+	}))
+
+	for _, tc := range []struct {
+		concurrency int
+		maxInflight int
+		limit       int32
+	}{
+		{4, 2, 2},
+		{3, 0, 3},
+	} {
+		peak.Store(0)
+
+		r := NewResolver().
+			WithServers([]string{server}).
+			WithSearch(false).
+			WithMode(ModeIpv4).
+			WithConcurrency(tc.concurrency).
+			WithMaxInflight(tc.maxInflight)
+
+		response, err := r.Resolve(context.Background(), names)
+		require.Nil(t, err)
+
+		addresses := make([][]string, 0, len(response))
+		for _, resp := range response {
+			addresses = append(addresses, resp.Addresses)
+		}
+		require.Equal(t, expected, addresses)
+		require.LessOrEqual(t, peak.Load(), tc.limit, "concurrency %d, max inflight %d", tc.concurrency, tc.maxInflight)
+		require.Greater(t, peak.Load(), int32(1), "concurrency %d, max inflight %d", tc.concurrency, tc.maxInflight)
+	}
+
+	r := NewResolver()
+	r.WithConcurrency(0)
+	require.Equal(t, 1, r.concurrency)
+
+	r.WithMaxInflight(-1)
+	require.Equal(t, 0, r.maxInflight)
+
+	release, err := r.acquire(context.Background(), "127.0.0.1:53")
+	require.Nil(t, err)
+	release()
+}
+
+func TestModeAll(t *testing.T) {
+	zone := zoneHandler(t,
+		"dual.example. 60 IN A 10.0.0.1",
+		"dual.example. 60 IN AAAA fd00::1",
+		"legacy.example. 60 IN A 10.0.0.2",
+		"broken.example. 60 IN A 10.0.0.3",
+	)
+
+	server := startServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		if req.Question[0].Name == "broken.example." && req.Question[0].Qtype == dns.TypeAAAA {
+			msg := new(dns.Msg)
+			msg.SetRcode(req, dns.RcodeServerFailure)
+			_ = w.WriteMsg(msg)
+			return
+		}
+		zone(w, req)
+	}))
+
+	r := NewResolver().
+		WithServers([]string{server}).
+		WithMode(ModeAll)
+
+	response, err := r.Resolve(context.Background(), []string{"dual.example", "legacy.example", "broken.example", "missing.example"})
+	require.Nil(t, err)
+
+	require.Equal(t, []Response{
+		{
+			Name:      "dual.example",
+			Addresses: []string{"10.0.0.1", "fd00::1"},
+			Server:    server,
+			Rcode:     "NOERROR",
+			TTLMin:    uint32Ptr(60),
+			TTLMax:    uint32Ptr(60),
+		},
+		{
+			Name:      "legacy.example",
+			Addresses: []string{"10.0.0.2"},
+			Server:    server,
+			Rcode:     "NOERROR",
+			TTLMin:    uint32Ptr(60),
+			TTLMax:    uint32Ptr(60),
+		},
+		{
+			Name:      "broken.example",
+			Addresses: []string{"10.0.0.3"},
+			Server:    server,
+			Partial:   true,
+			Errors:    []string{"AAAA: SERVFAIL"},
+			Rcode:     "NOERROR",
+			TTLMin:    uint32Ptr(60),
+			TTLMax:    uint32Ptr(60),
+		},
+		{
+			Name:      "missing.example",
+			Addresses: []string{},
+			Server:    server,
+			Rcode:     "NXDOMAIN",
+		},
+	}, stripTiming(response))
+
+	require.Equal(t, []Response{response[2]}, resolver.FilterResponsesPartial(response))
+	require.Len(t, resolver.FilterResponsesNoerror(response), 3)
+}
+
+func TestCNAMEs(t *testing.T) {
+	server := startServer(t, zoneHandler(t,
+		"www.example. 60 IN CNAME www.example.edge.example.",
+		"www.example.edge.example. 60 IN CNAME e1.cdn.example.",
+		"e1.cdn.example. 60 IN A 10.0.0.1",
+		"e1.cdn.example. 60 IN A 10.0.0.2",
+		"e1.cdn.example. 60 IN AAAA fd00::1",
+		"dangling.example. 60 IN CNAME gone.example.",
+	))
+
+	r := NewResolver().
+		WithServers([]string{server}).
+		WithMode(ModeAll)
+
+	response, err := r.Resolve(context.Background(), []string{"www.example", "dangling.example"})
+	require.Nil(t, err)
+
+	require.Equal(t, []Response{
+		{
+			Name:      "www.example",
+			Addresses: []string{"10.0.0.1", "10.0.0.2", "fd00::1"},
+			CNAMEs:    []string{"www.example.edge.example", "e1.cdn.example"},
+			Server:    server,
+			Rcode:     "NOERROR",
+			TTLMin:    uint32Ptr(60),
+			TTLMax:    uint32Ptr(60),
+		},
+		{
+			Name:      "dangling.example",
+			Addresses: []string{},
+			CNAMEs:    []string{"gone.example"},
+			Server:    server,
+			Rcode:     "NXDOMAIN",
+			TTLMin:    uint32Ptr(60),
+			TTLMax:    uint32Ptr(60),
+		},
+	}, stripTiming(response))
+}
+
+func TestSearch(t *testing.T) {
+	server := startServer(t, zoneHandler(t,
+		"db01.lab.example. 60 IN A 10.0.0.1",
+		"db01. 60 IN A 192.0.2.1",
+		"web.example. 60 IN A 10.0.0.2",
+		"web.example.corp.example. 60 IN A 10.0.0.3",
+	))
+
+	resolvConf := path.Join(t.TempDir(), "resolv.conf")
+	err := os.WriteFile(resolvConf, []byte("search corp.example lab.example\noptions ndots:1\n"), 0o644)
+	require.Nil(t, err)
+
+	r := NewResolver().WithServers([]string{server})
+	r.resolvConf = resolvConf
+
+	response, err := r.Resolve(context.Background(), []string{"db01", "web.example", "db02", "web.example."})
+	require.Nil(t, err)
+
+	require.Equal(t, []Response{
+		{
+			Name:      "db01",
+			FQDN:      "db01.lab.example",
+			Addresses: []string{"10.0.0.1"},
+			Server:    server,
+			Rcode:     "NOERROR",
+			TTLMin:    uint32Ptr(60),
+			TTLMax:    uint32Ptr(60),
+		},
+		{
+			Name:      "web.example",
+			Addresses: []string{"10.0.0.2"},
+			Server:    server,
+			Rcode:     "NOERROR",
+			TTLMin:    uint32Ptr(60),
+			TTLMax:    uint32Ptr(60),
+		},
+		{
+			Name:      "db02",
+			Addresses: []string{},
+			Server:    server,
+			Rcode:     "NXDOMAIN",
+		},
+		{
+			Name:      "web.example.",
+			Addresses: []string{"10.0.0.2"},
+			Server:    server,
+			Rcode:     "NOERROR",
+			TTLMin:    uint32Ptr(60),
+			TTLMax:    uint32Ptr(60),
+		},
+	}, stripTiming(response))
+
+	response, err = r.WithSearch(false).Resolve(context.Background(), []string{"db01"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"192.0.2.1"}, response[0].Addresses)
+	require.Equal(t, "", response[0].FQDN)
+
+	r.resolvConf = "/this/file/does/not/exist"
+	_, err = r.WithSearch(true).Resolve(context.Background(), []string{"db01"})
+	require.Nil(t, err)
+}
+
+func TestResponseMetadata(t *testing.T) {
+	server := startServer(t, zoneHandler(t,
+		"www.example. 300 IN CNAME edge.example.",
+		"edge.example. 30 IN A 10.0.0.1",
+		"edge.example. 60 IN A 10.0.0.2",
+	))
+
+	r := NewResolver().WithServers([]string{server})
+
+	before := time.Now()
+	response, err := r.Resolve(context.Background(), []string{"www.example", "missing.example"})
+	require.Nil(t, err)
+
+	require.Equal(t, "NOERROR", response[0].Rcode)
+	require.Equal(t, uint32Ptr(30), response[0].TTLMin)
+	require.Equal(t, uint32Ptr(300), response[0].TTLMax)
+	require.Equal(t, server, response[0].Server)
+	require.Greater(t, response[0].RTT, Duration(0))
+	require.NotNil(t, response[0].Timestamp)
+	require.False(t, response[0].Timestamp.Before(before))
+
+	require.Equal(t, "NXDOMAIN", response[1].Rcode)
+	require.Nil(t, response[1].TTLMin)
+	require.Nil(t, response[1].TTLMax)
+
+	content, err := json.Marshal(response[0].RTT)
+	require.Nil(t, err)
+
+	var rtt Duration
+	require.Nil(t, json.Unmarshal(content, &rtt))
+	require.Equal(t, response[0].RTT, rtt)
+}
+
+func TestResolveStream(t *testing.T) {
+	zone := zoneHandler(t,
+		"slow.example. 60 IN A 10.0.0.1",
+		"a.example. 60 IN A 10.0.0.2",
+		"b.example. 60 IN A 10.0.0.3",
+	)
+
+	server := startServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		if req.Question[0].Name == "slow.example." {
+			time.Sleep(100 * time.Millisecond)
+		}
+		zone(w, req)
+	}))
+
+	r := NewResolver().
+		WithServers([]string{server}).
+		WithMode(ModeIpv4).
+		WithConcurrency(3)
+
+	names := make([]string, 0)
+	for response, err := range r.ResolveStream(context.Background(), slices.Values([]string{"slow.example", "a.example", "b.example", "missing.example"})) {
+		require.Nil(t, err)
+		names = append(names, response.Name)
+	}
+	require.Equal(t, []string{"slow.example", "a.example", "b.example", "missing.example"}, names)
+
+	for response, err := range r.ResolveStream(context.Background(), slices.Values([]string{"a.example", "slow.example", "b.example"})) {
+		require.Nil(t, err)
+		require.Equal(t, "a.example", response.Name)
+		break
+	}
+
+	silent := startSilentServer(t)
+	r.WithServers([]string{silent}).
+		WithAttempts(1).
+		WithTimeout(50 * time.Millisecond)
+
+	count := 0
+	for _, err := range r.ResolveStream(context.Background(), slices.Values([]string{"a.example", "b.example"})) {
+		require.NotNil(t, err)
+		count++
+	}
+	require.Equal(t, 1, count)
+}
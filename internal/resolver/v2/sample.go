@@ -0,0 +1,125 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/pabateman/dns-lookuper/internal/resolver"
+)
+
+// WithSamples makes the resolver query every name n times and merge the
+// answers, so addresses load balancers hand out in turns are all caught.
+// Answers are never taken from the cache while sampling.
+func (r *Resolver) WithSamples(n int) *Resolver {
+	r.samples = max(n, 1)
+	return r
+}
+
+// WithSampleInterval sets the pause between samples of a name.
+func (r *Resolver) WithSampleInterval(d time.Duration) *Resolver {
+	r.sampleInterval = d
+	return r
+}
+
+// WithSampleServers makes every sample query each of the upstream servers
+// instead of the first one answering.
+func (r *Resolver) WithSampleServers(s bool) *Resolver {
+	r.sampleServers = s
+	return r
+}
+
+func (r *Resolver) sampling() bool {
+	return r.samples > 1 || r.sampleServers
+}
+
+// sample resolves fqdn until the number of samples is taken and merges them
+// into the first response. Samples answered with other rcodes than NOERROR
+// are not counted, failed ones make the response partial.
+func (r *Resolver) sample(ctx context.Context, u *upstreams, first Response, fqdn string) (Response, error) {
+	if !r.sampling() {
+		return first, nil
+	}
+
+	samples := make([]Response, 0, r.samples*len(u.servers))
+	failures := make([]string, 0)
+
+	if !r.sampleServers {
+		samples = append(samples, first)
+	}
+
+	for i := len(samples); i < r.samples; i++ {
+		if i > 0 {
+			err := sleep(ctx, r.sampleInterval)
+			if err != nil {
+				return first, err
+			}
+		}
+
+		targets := []*upstreams{u}
+		if r.sampleServers {
+			targets = u.split()
+		}
+
+		for _, target := range targets {
+			response, _, err := r.resolveFQDN(ctx, first.Name, fqdn, target)
+			if ctx.Err() != nil {
+				return first, ctx.Err()
+			}
+
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("sample %d: %+v", i+1, err))
+				continue
+			}
+
+			if response.Rcode == dns.RcodeToString[dns.RcodeSuccess] {
+				samples = append(samples, response)
+			}
+		}
+	}
+
+	if len(samples) == 0 {
+		first.Errors = append(first.Errors, failures...)
+		first.Partial = true
+		return first, nil
+	}
+
+	appearances := make(map[string]int)
+	for _, sample := range samples {
+		for _, value := range sample.Values() {
+			appearances[value]++
+		}
+	}
+
+	result := resolver.Union(samples)[0]
+	result.Samples = len(samples)
+	result.Appearances = appearances
+	result.Errors = append(result.Errors, failures...)
+	result.Partial = len(result.Errors) > 0
+	result.Wildcard = first.Wildcard
+
+	return result, nil
+}
+
+// split returns upstreams with one server each sharing clients of u.
+func (u *upstreams) split() []*upstreams {
+	result := make([]*upstreams, 0, len(u.servers))
+
+	for _, server := range u.servers {
+		result = append(result, &upstreams{
+			client:     u.client,
+			tcpClient:  u.tcpClient,
+			tlsClients: u.tlsClients,
+			dohClients: u.dohClients,
+			servers:    []string{server},
+			attempts:   u.attempts,
+			search:     u.search,
+			wildcards:  make(map[string]*wildcardProbe),
+			keys:       make(map[string]*keysProbe),
+		})
+	}
+
+	return result
+}
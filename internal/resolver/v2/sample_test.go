@@ -0,0 +1,90 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// rotatingHandler answers with two of the addresses at a time, moving on by
+// one address per query like DNS load balancers do.
+func rotatingHandler(addresses ...string) dns.HandlerFunc {
+	var mu sync.Mutex
+	next := 0
+
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(req)
+
+		mu.Lock()
+		offset := next
+		next++
+		mu.Unlock()
+
+		for i := range 2 {
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP(addresses[(offset+i)%len(addresses)]),
+			})
+		}
+
+		_ = w.WriteMsg(msg)
+	}
+}
+
+func TestSamples(t *testing.T) {
+	server := startServer(t, rotatingHandler("192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"))
+
+	r := NewResolver().
+		WithServers([]string{server}).
+		WithCache(NewCache())
+
+	response, err := r.Resolve(context.Background(), []string{"lb.example"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"192.0.2.1", "192.0.2.2"}, response[0].Addresses)
+	require.Zero(t, response[0].Samples)
+
+	r.WithSamples(4).WithSampleInterval(10 * time.Millisecond)
+
+	start := time.Now()
+	response, err = r.Resolve(context.Background(), []string{"lb.example"})
+	require.Nil(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+
+	require.Equal(t, []string{"192.0.2.2", "192.0.2.3", "192.0.2.4", "192.0.2.1"}, response[0].Addresses)
+	require.Equal(t, 4, response[0].Samples)
+	require.Equal(t, map[string]int{
+		"192.0.2.1": 2,
+		"192.0.2.2": 2,
+		"192.0.2.3": 2,
+		"192.0.2.4": 2,
+	}, response[0].Appearances)
+	require.False(t, response[0].Partial)
+}
+
+func TestSampleServers(t *testing.T) {
+	first := startServer(t, zoneHandler(t, "lb.example. 60 IN A 192.0.2.1"))
+	second := startServer(t, zoneHandler(t, "lb.example. 60 IN A 192.0.2.2"))
+	silent := startSilentServer(t)
+
+	r := NewResolver().
+		WithServers([]string{first, second, silent}).
+		WithTimeout(100 * time.Millisecond).
+		WithAttempts(1).
+		WithSamples(2).
+		WithSampleServers(true)
+
+	response, err := r.Resolve(context.Background(), []string{"lb.example"})
+	require.Nil(t, err)
+
+	require.Equal(t, []string{"192.0.2.1", "192.0.2.2"}, response[0].Addresses)
+	require.Equal(t, 4, response[0].Samples)
+	require.Equal(t, map[string]int{"192.0.2.1": 2, "192.0.2.2": 2}, response[0].Appearances)
+	require.True(t, response[0].Partial)
+	require.Len(t, response[0].Errors, 2)
+}
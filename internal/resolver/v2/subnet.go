@@ -0,0 +1,90 @@
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	SubnetBitsIPv4Default = 24
+	SubnetBitsIPv6Default = 56
+
+	// ednsSize is the UDP payload size advertised along with the client
+	// subnet; it is the size recommended to avoid fragmentation.
+	ednsSize = 1232
+)
+
+// ParseSubnet accepts a client subnet as "addr/bits" or as a bare address,
+// which is truncated to SubnetBitsIPv4Default or SubnetBitsIPv6Default bits.
+// Host bits are zeroed, so servers get no more of the address than the prefix.
+func ParseSubnet(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid client subnet %q", s)
+		}
+
+		bits := SubnetBitsIPv4Default
+		if addr.Is6() {
+			bits = SubnetBitsIPv6Default
+		}
+
+		return addr.Prefix(bits)
+	}
+
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid client subnet %q", s)
+	}
+
+	return prefix.Masked(), nil
+}
+
+// WithClientSubnet attaches an EDNS0 Client Subnet option with the prefix to
+// every query, so servers answer as they would to clients of the subnet;
+// responses are labelled with the subnet. The zero prefix disables it.
+func (r *Resolver) WithClientSubnet(p netip.Prefix) *Resolver {
+	r.subnet = p
+	return r
+}
+
+func (r *Resolver) setClientSubnet(msg *dns.Msg) {
+	if !r.subnet.IsValid() {
+		return
+	}
+
+	family := uint16(1)
+	if r.subnet.Addr().Is6() {
+		family = 2
+	}
+
+	msg.SetEdns0(ednsSize, false)
+	opt := msg.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        family,
+		SourceNetmask: uint8(r.subnet.Bits()),
+		Address:       net.IP(r.subnet.Addr().AsSlice()),
+	})
+}
+
+// clientSubnet returns the client subnet of the query, if any, so answers
+// for different subnets are cached apart.
+func clientSubnet(msg *dns.Msg) string {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return ""
+	}
+
+	for _, option := range opt.Option {
+		if subnet, ok := option.(*dns.EDNS0_SUBNET); ok {
+			return fmt.Sprintf("%s/%d", subnet.Address, subnet.SourceNetmask)
+		}
+	}
+
+	return ""
+}
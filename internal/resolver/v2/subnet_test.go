@@ -0,0 +1,88 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSubnet(t *testing.T) {
+	valid := map[string]string{
+		"198.51.100.7":      "198.51.100.0/24",
+		"198.51.100.7/32":   "198.51.100.7/32",
+		"198.51.100.7/16":   "198.51.0.0/16",
+		"2001:db8:1:2::1":   "2001:db8:1::/56",
+		"2001:db8::/32":     "2001:db8::/32",
+		"2001:db8:1::1/128": "2001:db8:1::1/128",
+	}
+
+	for input, expected := range valid {
+		actual, err := ParseSubnet(input)
+		require.Nil(t, err, input)
+		require.Equal(t, expected, actual.String())
+	}
+
+	invalid := []string{
+		"",
+		"example.com",
+		"198.51.100.0/33",
+		"198.51.100/24",
+	}
+
+	for _, input := range invalid {
+		_, err := ParseSubnet(input)
+		require.NotNil(t, err, input)
+	}
+}
+
+func TestClientSubnet(t *testing.T) {
+	europe := netip.MustParsePrefix("198.51.100.0/24")
+	asia := netip.MustParsePrefix("203.0.113.0/24")
+
+	server := startServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(req)
+
+		address := net.IPv4(192, 0, 2, 1)
+		if clientSubnet(req) == asia.String() {
+			address = net.IPv4(192, 0, 2, 2)
+		}
+		if clientSubnet(req) == "" {
+			address = net.IPv4(192, 0, 2, 3)
+		}
+
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   address,
+		})
+		_ = w.WriteMsg(msg)
+	}))
+
+	r := NewResolver().
+		WithServers([]string{server}).
+		WithCache(NewCache())
+
+	expected := map[netip.Prefix]string{
+		europe:         "192.0.2.1",
+		asia:           "192.0.2.2",
+		netip.Prefix{}: "192.0.2.3",
+	}
+
+	for range 2 {
+		for subnet, address := range expected {
+			response, err := r.WithClientSubnet(subnet).Resolve(context.Background(), []string{"cdn.example"})
+			require.Nil(t, err)
+			require.Equal(t, []string{address}, response[0].Addresses)
+
+			if subnet.IsValid() {
+				require.Equal(t, subnet.String(), response[0].Subnet)
+			} else {
+				require.Empty(t, response[0].Subnet)
+			}
+		}
+	}
+}
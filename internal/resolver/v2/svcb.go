@@ -0,0 +1,137 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/miekg/dns"
+
+	"github.com/pabateman/dns-lookuper/internal/resolver"
+)
+
+type SVCB = resolver.SVCB
+
+// MaxAliasesDefault limits alias-mode SVCB and HTTPS records followed from
+// a name, which also breaks loops of aliases.
+const MaxAliasesDefault = 8
+
+// addSVCB adds the record to the response along with addresses of its
+// ipv4hint and ipv6hint params that are not there yet, marking the record
+// they came from.
+func addSVCB(r *Response, recordType string, rr *dns.SVCB) {
+	svcb := SVCB{
+		Type:     recordType,
+		Priority: rr.Priority,
+		Target:   trimDot(rr.Target),
+	}
+
+	owner := trimDot(rr.Hdr.Name)
+
+	for _, kv := range rr.Value {
+		if svcb.Params == nil {
+			svcb.Params = make(map[string]string)
+		}
+		svcb.Params[kv.Key().String()] = kv.String()
+
+		var hints []net.IP
+		switch kv := kv.(type) {
+		case *dns.SVCBIPv4Hint:
+			hints = kv.Hint
+		case *dns.SVCBIPv6Hint:
+			hints = kv.Hint
+		}
+
+		for _, hint := range hints {
+			address := hint.String()
+			if slices.Contains(r.Addresses, address) {
+				continue
+			}
+
+			if r.Provenance == nil {
+				r.Provenance = make(map[string]string)
+			}
+			r.Provenance[address] = fmt.Sprintf("%s %s %s", recordType, owner, kv.Key())
+			r.Addresses = append(r.Addresses, address)
+		}
+	}
+
+	r.SVCB = append(r.SVCB, svcb)
+}
+
+// followAliases queries targets of alias-mode records of the answer with the
+// same type and adds their answers to the response. Aliases that fail are
+// reported in Errors.
+func (r *Resolver) followAliases(ctx context.Context, u *upstreams, result *Response, qtype uint16, answer []dns.RR) error {
+	if qtype != dns.TypeSVCB && qtype != dns.TypeHTTPS {
+		return nil
+	}
+
+	visited := make(map[string]bool)
+
+	for range MaxAliasesDefault {
+		owner, target := aliasTarget(answer)
+		if target == "" {
+			return nil
+		}
+		visited[owner] = true
+
+		name := fmt.Sprintf("%s alias %s", dns.TypeToString[qtype], trimDot(target))
+		if visited[dns.CanonicalName(target)] {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: alias loop", name))
+			return nil
+		}
+
+		query := newQuery(target, qtype)
+		r.setClientSubnet(query)
+		r.setDNSSEC(query)
+
+		reply, err := r.exchange(ctx, u, query)
+		if err == nil && r.dnssec == DNSSECRequire && validated(reply.msg.Rcode) {
+			err = r.validate(ctx, u, reply.msg)
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %+v", name, err))
+			return nil
+		}
+
+		if reply.msg.Rcode != dns.RcodeSuccess {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %s", name, dns.RcodeToString[reply.msg.Rcode]))
+			return nil
+		}
+
+		answer = stripSignatures(reply.msg.Answer, qtype)
+		addAnswer(result, answer)
+		result.AD = result.AD && reply.msg.AuthenticatedData
+		result.RTT = max(result.RTT, Duration(reply.rtt))
+		result.Cached = result.Cached && reply.cached
+	}
+
+	return nil
+}
+
+// aliasTarget returns the owner and the target of the alias-mode record of
+// the answer; the "." target of an alias means the service is not available.
+func aliasTarget(answer []dns.RR) (string, string) {
+	for _, rr := range answer {
+		var svcb *dns.SVCB
+		switch rr := rr.(type) {
+		case *dns.SVCB:
+			svcb = rr
+		case *dns.HTTPS:
+			svcb = &rr.SVCB
+		}
+
+		if svcb != nil && svcb.Priority == 0 && svcb.Target != "." {
+			return dns.CanonicalName(svcb.Hdr.Name), svcb.Target
+		}
+	}
+
+	return "", ""
+}
@@ -0,0 +1,73 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSVCB(t *testing.T) {
+	server := startServer(t, zoneHandler(t,
+		"svc.example. 60 IN HTTPS 0 pool.example.",
+		"pool.example. 60 IN HTTPS 1 . alpn=h3,h2 port=8443 ipv4hint=192.0.2.1,192.0.2.2 ipv6hint=2001:db8::1",
+		"direct.example. 60 IN HTTPS 1 . alpn=h2 ipv4hint=192.0.2.9",
+		"direct.example. 60 IN HTTPS 2 backup.example. ipv4hint=192.0.2.9,192.0.2.10",
+		"_dns.resolver.example. 60 IN SVCB 1 dns.example. alpn=dot",
+		"loop-a.example. 60 IN HTTPS 0 loop-b.example.",
+		"loop-b.example. 60 IN HTTPS 0 loop-a.example.",
+		"broken.example. 60 IN HTTPS 0 gone.example.",
+		"dual.example. 60 IN A 192.0.2.20",
+		"dual.example. 60 IN HTTPS 1 . ipv4hint=192.0.2.20,192.0.2.21",
+	))
+
+	r := NewResolver().
+		WithServers([]string{server}).
+		WithSearch(false).
+		WithMode("https")
+
+	response, err := r.Resolve(context.Background(), []string{"svc.example", "direct.example", "loop-a.example", "broken.example"})
+	require.Nil(t, err)
+
+	require.Equal(t, []string{"192.0.2.1", "192.0.2.2", "2001:db8::1"}, response[0].Addresses)
+	require.Equal(t, map[string]string{
+		"192.0.2.1":   "HTTPS pool.example ipv4hint",
+		"192.0.2.2":   "HTTPS pool.example ipv4hint",
+		"2001:db8::1": "HTTPS pool.example ipv6hint",
+	}, response[0].Provenance)
+	require.Equal(t, []SVCB{
+		{Type: "HTTPS", Priority: 0, Target: "pool.example"},
+		{Type: "HTTPS", Priority: 1, Target: ".", Params: map[string]string{
+			"alpn":     "h3,h2",
+			"port":     "8443",
+			"ipv4hint": "192.0.2.1,192.0.2.2",
+			"ipv6hint": "2001:db8::1",
+		}},
+	}, response[0].SVCB)
+	require.False(t, response[0].Partial)
+
+	require.Equal(t, []string{"192.0.2.9", "192.0.2.10"}, response[1].Addresses)
+	require.Equal(t, "HTTPS direct.example ipv4hint", response[1].Provenance["192.0.2.10"])
+	require.Len(t, response[1].SVCB, 2)
+
+	require.Len(t, response[2].SVCB, 2)
+	require.Equal(t, []string{"HTTPS alias loop-a.example: alias loop"}, response[2].Errors)
+
+	require.Equal(t, "NOERROR", response[3].Rcode)
+	require.True(t, response[3].Partial)
+	require.Equal(t, []string{"HTTPS alias gone.example: NXDOMAIN"}, response[3].Errors)
+
+	response, err = r.WithMode("svcb").Resolve(context.Background(), []string{"_dns.resolver.example"})
+	require.Nil(t, err)
+	require.Equal(t, []SVCB{{Type: "SVCB", Priority: 1, Target: "dns.example", Params: map[string]string{"alpn": "dot"}}}, response[0].SVCB)
+	require.Empty(t, response[0].Addresses)
+
+	// Addresses answered by A records are not hints, whichever type comes
+	// first.
+	for _, types := range [][]string{{"A", "HTTPS"}, {"HTTPS", "A"}} {
+		response, err = r.WithTypes(types).Resolve(context.Background(), []string{"dual.example"})
+		require.Nil(t, err)
+		require.ElementsMatch(t, []string{"192.0.2.20", "192.0.2.21"}, response[0].Addresses, types)
+		require.Equal(t, map[string]string{"192.0.2.21": "HTTPS dual.example ipv4hint"}, response[0].Provenance, types)
+	}
+}
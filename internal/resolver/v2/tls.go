@@ -0,0 +1,88 @@
+package resolver
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// NewTLSConfig builds the TLS configuration for encrypted upstreams. caFile
+// replaces system roots when set, serverName overrides the name verified in
+// the server certificate, and spkiPins are base64 encoded SHA-256 digests of
+// SubjectPublicKeyInfo one of which must match a certificate of the verified
+// chain.
+func NewTLSConfig(serverName string, caFile string, spkiPins []string) (*tls.Config, error) {
+	result := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("there are no certificates in %s", caFile)
+		}
+		result.RootCAs = pool
+	}
+
+	for _, pin := range spkiPins {
+		digest, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("invalid spki pin %q; it must be base64 encoded sha256 digest", pin)
+		}
+	}
+
+	if len(spkiPins) > 0 {
+		// Only verified chains count: the peer may send any certificates
+		// along with them, pinned ones included.
+		result.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, chain := range cs.VerifiedChains {
+				for _, cert := range chain {
+					if slices.Contains(spkiPins, SPKIPin(cert)) {
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("no certificate of %s matches spki pins", cs.ServerName)
+		}
+	}
+
+	return result, nil
+}
+
+// SPKIPin returns the pin of the certificate public key in the form accepted by
+// NewTLSConfig.
+func SPKIPin(cert *x509.Certificate) string {
+	digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// WithTLSConfig sets the TLS configuration shared by encrypted upstreams.
+func (r *Resolver) WithTLSConfig(c *tls.Config) *Resolver {
+	r.tlsConfig = c
+	return r
+}
+
+// tlsConfigFor verifies the server certificate against the upstream host,
+// either a name or an address, unless the server name is set explicitly.
+func (r *Resolver) tlsConfigFor(host string) *tls.Config {
+	result := &tls.Config{MinVersion: tls.VersionTLS12}
+	if r.tlsConfig != nil {
+		result = r.tlsConfig.Clone()
+	}
+
+	if result.ServerName == "" {
+		result.ServerName = host
+	}
+
+	return result
+}
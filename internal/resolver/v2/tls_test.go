@@ -0,0 +1,169 @@
+package resolver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// newCertificate issues a self-signed certificate for 127.0.0.1 and dns.test
+// and stores it in PEM format to be used as a CA bundle.
+func newCertificate(t *testing.T) (tls.Certificate, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dns.test"},
+		DNSNames:              []string{"dns.test"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+
+	leaf, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+
+	caFile := path.Join(t.TempDir(), "ca.pem")
+	err = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+	require.Nil(t, err)
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, caFile
+}
+
+// startTLSServer serves handler over DNS-over-TLS and returns the address of
+// the server along with the function reporting the last received SNI.
+func startTLSServer(t *testing.T, cert tls.Certificate, handler dns.Handler) (string, func() string) {
+	t.Helper()
+
+	var mu sync.Mutex
+	sni := ""
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			mu.Lock()
+			sni = hello.ServerName
+			mu.Unlock()
+			return nil, nil
+		},
+	})
+	require.Nil(t, err)
+
+	serve(t, &dns.Server{Net: "tcp-tls", Listener: listener, Handler: handler})
+
+	return listener.Addr().String(), func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return sni
+	}
+}
+
+func TestTLS(t *testing.T) {
+	cert, caFile := newCertificate(t)
+	address, sni := startTLSServer(t, cert, zoneHandler(t, "secure.example. 60 IN A 10.0.0.1"))
+	server := SchemeTLS + "://" + address
+
+	r := NewResolver().
+		WithServers([]string{server}).
+		WithAttempts(1).
+		WithTimeout(time.Second)
+
+	_, err := r.Resolve(context.Background(), []string{"secure.example"})
+	require.NotNil(t, err)
+
+	config, err := NewTLSConfig("", caFile, nil)
+	require.Nil(t, err)
+
+	response, err := r.WithTLSConfig(config).Resolve(context.Background(), []string{"secure.example"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, response[0].Addresses)
+	require.Equal(t, server, response[0].Server)
+	require.Equal(t, "", sni())
+
+	config, err = NewTLSConfig("dns.test", caFile, nil)
+	require.Nil(t, err)
+
+	_, err = r.WithTLSConfig(config).Resolve(context.Background(), []string{"secure.example"})
+	require.Nil(t, err)
+	require.Equal(t, "dns.test", sni())
+
+	config, err = NewTLSConfig("other.test", caFile, nil)
+	require.Nil(t, err)
+
+	_, err = r.WithTLSConfig(config).Resolve(context.Background(), []string{"secure.example"})
+	require.NotNil(t, err)
+}
+
+func TestTLSPins(t *testing.T) {
+	cert, caFile := newCertificate(t)
+	address, _ := startTLSServer(t, cert, zoneHandler(t, "secure.example. 60 IN A 10.0.0.1"))
+
+	r := NewResolver().
+		WithServers([]string{SchemeTLS + "://" + address}).
+		WithAttempts(1).
+		WithTimeout(time.Second)
+
+	config, err := NewTLSConfig("", caFile, []string{SPKIPin(cert.Leaf)})
+	require.Nil(t, err)
+
+	_, err = r.WithTLSConfig(config).Resolve(context.Background(), []string{"secure.example"})
+	require.Nil(t, err)
+
+	wrong := sha256.Sum256([]byte("wrong"))
+	config, err = NewTLSConfig("", caFile, []string{base64.StdEncoding.EncodeToString(wrong[:])})
+	require.Nil(t, err)
+
+	_, err = r.WithTLSConfig(config).Resolve(context.Background(), []string{"secure.example"})
+	require.NotNil(t, err)
+
+	// A pinned certificate sent along with a trusted chain must not pass.
+	pinned, _ := newCertificate(t)
+	chained := tls.Certificate{
+		Certificate: [][]byte{cert.Certificate[0], pinned.Certificate[0]},
+		PrivateKey:  cert.PrivateKey,
+	}
+	address, _ = startTLSServer(t, chained, zoneHandler(t, "secure.example. 60 IN A 10.0.0.1"))
+
+	config, err = NewTLSConfig("", caFile, []string{SPKIPin(pinned.Leaf)})
+	require.Nil(t, err)
+
+	_, err = r.WithServers([]string{SchemeTLS + "://" + address}).WithTLSConfig(config).Resolve(context.Background(), []string{"secure.example"})
+	require.NotNil(t, err)
+
+	_, err = NewTLSConfig("", caFile, []string{"not a pin"})
+	require.NotNil(t, err)
+
+	_, err = NewTLSConfig("", path.Join(t.TempDir(), "missing.pem"), nil)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,538 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PortDefault    = "53"
+	PortTLS        = "853"
+	SchemeTLS      = "tls"
+	ResolvConfPath = "/etc/resolv.conf"
+)
+
+const (
+	TransportUDP     = "udp"
+	TransportTCP     = "tcp"
+	TransportDefault = TransportUDP
+)
+
+// ParseServer accepts "host", "host:port", "addr" and "[addr]:port" forms of
+// an upstream server and returns it as "host:port". DNS-over-TLS servers are
+// prefixed with "tls://" and use port 853 by default, DNS-over-HTTPS servers
+// are URLs with "https://" scheme and "/dns-query" path by default.
+func ParseServer(s string) (string, error) {
+	scheme, address, found := strings.Cut(s, "://")
+	if !found {
+		return parseHostPort(s, PortDefault)
+	}
+
+	switch scheme {
+	case SchemeTLS:
+		hostport, err := parseHostPort(address, PortTLS)
+		if err != nil {
+			return "", err
+		}
+		return scheme + "://" + hostport, nil
+	case SchemeHTTPS:
+		return parseDoHURL(s)
+	default:
+		return "", fmt.Errorf("unsupported scheme in server address %q", s)
+	}
+}
+
+func parseHostPort(s string, defaultPort string) (string, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return net.JoinHostPort(addr.String(), defaultPort), nil
+	}
+
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+		port = defaultPort
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		host = addr.String()
+	} else if !govalidator.IsDNSName(host) {
+		return "", fmt.Errorf("invalid server address %q", s)
+	}
+
+	if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+		return "", fmt.Errorf("invalid port in server address %q", s)
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// upstreams is the set of servers with retry options used by one Resolve call.
+type upstreams struct {
+	client     *dns.Client
+	tcpClient  *dns.Client
+	tlsClients map[string]*dns.Client
+	dohClients map[string]*http.Client
+	servers    []string
+	attempts   int
+	rotate     bool
+	next       atomic.Uint32
+	search     *dns.ClientConfig
+
+	wildcards   map[string]*wildcardProbe
+	wildcardsMu sync.Mutex
+
+	keys   map[string]*keysProbe
+	keysMu sync.Mutex
+}
+
+// upstreams takes servers from the resolver, root hints in iterative mode, or
+// from resolv.conf along with its attempts, timeout and rotate options;
+// options set on the resolver win. The search list and ndots of resolv.conf
+// are used with any servers unless the search is disabled.
+func (r *Resolver) upstreams() (*upstreams, error) {
+	servers := r.servers
+	if r.iterative {
+		servers = r.rootHints
+	}
+
+	result := &upstreams{
+		servers:   servers,
+		attempts:  AttemptsDefault,
+		rotate:    r.rotate,
+		wildcards: make(map[string]*wildcardProbe),
+		keys:      make(map[string]*keysProbe),
+	}
+	timeout := TimeoutDefault
+
+	var config *dns.ClientConfig
+	if len(servers) == 0 || r.search {
+		var err error
+		config, err = dns.ClientConfigFromFile(r.resolvConf)
+		if err != nil && len(servers) == 0 {
+			return nil, err
+		}
+	}
+
+	if r.search && config != nil {
+		result.search = config
+	}
+
+	if len(servers) == 0 {
+		if len(config.Servers) == 0 {
+			return nil, fmt.Errorf("there are no nameservers in %s", r.resolvConf)
+		}
+
+		result.servers = make([]string, 0, len(config.Servers))
+		for _, server := range config.Servers {
+			result.servers = append(result.servers, net.JoinHostPort(server, config.Port))
+		}
+
+		rotate, err := resolvConfRotate(r.resolvConf)
+		if err != nil {
+			return nil, err
+		}
+
+		result.attempts = max(config.Attempts, 1)
+		result.rotate = result.rotate || rotate
+		timeout = time.Duration(config.Timeout) * time.Second
+	}
+
+	if r.attempts > 0 {
+		result.attempts = r.attempts
+	}
+
+	if r.timeout > 0 {
+		timeout = r.timeout
+	}
+
+	result.tcpClient = &dns.Client{
+		Net:     TransportTCP,
+		Timeout: timeout,
+	}
+
+	result.client = result.tcpClient
+	if r.transport != TransportTCP {
+		result.client = &dns.Client{
+			Net:     TransportUDP,
+			Timeout: timeout,
+		}
+	}
+
+	result.tlsClients = make(map[string]*dns.Client)
+	result.dohClients = make(map[string]*http.Client)
+	for _, server := range result.servers {
+		if strings.HasPrefix(server, SchemeHTTPS+"://") {
+			client, err := r.newDoHClient(server, timeout)
+			if err != nil {
+				return nil, err
+			}
+
+			result.dohClients[server] = client
+			continue
+		}
+
+		address, ok := strings.CutPrefix(server, SchemeTLS+"://")
+		if !ok {
+			continue
+		}
+
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+
+		result.tlsClients[server] = &dns.Client{
+			Net:       "tcp-tls",
+			Timeout:   timeout,
+			TLSConfig: r.tlsConfigFor(host),
+		}
+	}
+
+	return result, nil
+}
+
+// close releases idle connections of DNS-over-HTTPS clients, which would
+// otherwise outlive the call in daemon mode.
+func (u *upstreams) close() {
+	for _, client := range u.dohClients {
+		client.CloseIdleConnections()
+	}
+}
+
+// nameList returns names to try in order: the name expanded with search
+// domains according to ndots, or just the fully qualified name when the
+// search is disabled or the name is an address for reverse lookups.
+func (u *upstreams) nameList(name string) []string {
+	if _, err := netip.ParseAddr(name); u.search == nil || err == nil {
+		return []string{dns.Fqdn(name)}
+	}
+
+	return u.search.NameList(name)
+}
+
+// resolvConfRotate looks for the rotate option, which dns.ClientConfig does
+// not parse.
+func resolvConfRotate(path string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	rotate := false
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[0] == "options" && slices.Contains(fields[1:], "rotate") {
+			rotate = true
+		}
+	}
+
+	return rotate, nil
+}
+
+// order returns servers in the order they are tried for the next query.
+func (u *upstreams) order() []string {
+	if !u.rotate || len(u.servers) < 2 {
+		return u.servers
+	}
+
+	offset := int(u.next.Add(1)-1) % len(u.servers)
+	return slices.Concat(u.servers[offset:], u.servers[:offset])
+}
+
+// reply is the response to a query along with the server which sent it.
+type reply struct {
+	msg       *dns.Msg
+	server    string
+	rtt       time.Duration
+	timestamp time.Time
+	cached    bool
+}
+
+// exchange sends the query to upstream servers, or walks the hierarchy of
+// authoritative servers from the root in iterative mode.
+func (r *Resolver) exchange(ctx context.Context, u *upstreams, msg *dns.Msg) (*reply, error) {
+	if r.iterative {
+		depth := r.maxDepth
+		return r.iterate(ctx, u, msg, &depth)
+	}
+
+	return r.exchangeServers(ctx, u, msg, u.servers, u.order)
+}
+
+// exchangeServers sends the query to servers one after another until one of
+// them answers, making u.attempts passes over the list with growing backoff
+// between passes. Like the resolver of libc, it moves on to the next server
+// on SERVFAIL, REFUSED and NOTIMP and returns the last of such responses
+// only when no server answers otherwise. A server with no free in-flight
+// slots is moved to the end of the pass, so one slow server does not hold up
+// the others. Fresh responses of any of the servers are taken from the cache
+// when it is set and ctx does not bypass it.
+func (r *Resolver) exchangeServers(ctx context.Context, u *upstreams, msg *dns.Msg, servers []string, order func() []string) (*reply, error) {
+	if r.cache != nil && !r.sampling() && !bypassed(ctx) {
+		for _, server := range servers {
+			if cached, ok := r.cache.get(msg, server); ok {
+				return cached, nil
+			}
+		}
+	}
+
+	var err error
+	var failed *reply
+
+	for attempt := range u.attempts {
+		if attempt > 0 && r.backoff > 0 {
+			if err := sleep(ctx, r.backoff<<(attempt-1)); err != nil {
+				return nil, err
+			}
+		}
+
+		queue := order()
+		postponed := make(map[string]bool)
+
+		for len(queue) > 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			server := queue[0]
+			queue = queue[1:]
+
+			release, ok := r.tryAcquire(server)
+			if !ok {
+				if !postponed[server] && len(queue) > 0 {
+					postponed[server] = true
+					queue = append(queue, server)
+					continue
+				}
+
+				release, err = r.acquire(ctx, server)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			var throttled time.Duration
+			throttled, err = r.throttle(ctx, server)
+			if err != nil {
+				release()
+				return nil, err
+			}
+
+			var response *dns.Msg
+			var rtt time.Duration
+			timestamp := time.Now()
+			response, rtt, err = r.exchangeServer(ctx, u, msg, server)
+			release()
+
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			r.record(server, throttled, err)
+
+			if err == nil {
+				result := &reply{
+					msg:       response,
+					server:    server,
+					rtt:       rtt,
+					timestamp: timestamp,
+				}
+
+				if serverFailure(response.Rcode) {
+					failed = result
+					continue
+				}
+
+				if r.cache != nil {
+					r.cache.set(msg, result)
+				}
+
+				return result, nil
+			}
+		}
+	}
+
+	if failed != nil {
+		if r.cache != nil {
+			r.cache.set(msg, failed)
+		}
+
+		return failed, nil
+	}
+
+	return nil, err
+}
+
+// serverFailure tells whether the rcode is about the server rather than the
+// name, so other servers may answer.
+func serverFailure(rcode int) bool {
+	return rcode == dns.RcodeServerFailure || rcode == dns.RcodeRefused || rcode == dns.RcodeNotImplemented
+}
+
+// exchangeServer sends the query with the protocol of the server; plain DNS
+// queries are repeated over TCP when the UDP response is truncated.
+func (r *Resolver) exchangeServer(ctx context.Context, u *upstreams, msg *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	if client, ok := u.dohClients[server]; ok {
+		start := time.Now()
+		response, err := r.exchangeDoH(ctx, client, msg, server)
+		return response, time.Since(start), err
+	}
+
+	if client, ok := u.tlsClients[server]; ok {
+		return exchangeContext(ctx, client, msg, strings.TrimPrefix(server, SchemeTLS+"://"))
+	}
+
+	response, rtt, err := exchangeContext(ctx, u.client, msg, server)
+	if err != nil || !response.Truncated || u.client == u.tcpClient {
+		return response, rtt, err
+	}
+
+	log.Infof("truncated response for %s from %s, falling back to tcp", msg.Question[0].Name, server)
+
+	return exchangeContext(ctx, u.tcpClient, msg, server)
+}
+
+// exchangeContext is dns.Client.ExchangeContext which also gives up on
+// cancellation of ctx; the client honors only its deadline.
+func exchangeContext(ctx context.Context, client *dns.Client, msg *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	conn, err := client.DialContext(ctx, server)
+	if err != nil {
+		return nil, 0, deadlineErr(ctx, err)
+	}
+
+	// nolint:errcheck
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+
+	reply, rtt, err := client.ExchangeWithConnContext(ctx, msg, conn)
+	return reply, rtt, deadlineErr(ctx, err)
+}
+
+// deadlineErr reports the error of ctx instead of err when the deadline of
+// ctx has passed; socket deadlines taken from ctx may expire a moment before
+// ctx itself is done.
+func deadlineErr(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// sleep waits for the duration unless ctx is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// UpstreamStats sums up queries sent to an upstream server.
+type UpstreamStats struct {
+	Queries   int
+	Failures  int
+	Throttled time.Duration
+}
+
+// Stats returns counters of queries sent by the resolver so far per server;
+// answers taken from the cache are not counted.
+func (r *Resolver) Stats() map[string]UpstreamStats {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+
+	result := make(map[string]UpstreamStats, len(r.stats))
+	for server, stats := range r.stats {
+		result[server] = *stats
+	}
+
+	return result
+}
+
+func (r *Resolver) record(server string, throttled time.Duration, err error) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+
+	stats, ok := r.stats[server]
+	if !ok {
+		stats = &UpstreamStats{}
+		r.stats[server] = stats
+	}
+
+	stats.Queries++
+	stats.Throttled += throttled
+	if err != nil {
+		stats.Failures++
+	}
+}
+
+func (r *Resolver) slots(server string) chan struct{} {
+	r.inflightMu.Lock()
+	defer r.inflightMu.Unlock()
+
+	slots, ok := r.inflight[server]
+	if !ok {
+		slots = make(chan struct{}, r.maxInflight)
+		r.inflight[server] = slots
+	}
+
+	return slots
+}
+
+// acquire blocks until an in-flight slot for the server is free or ctx is
+// done and returns the function releasing the slot; zero maxInflight means
+// no cap.
+func (r *Resolver) acquire(ctx context.Context, server string) (func(), error) {
+	if r.maxInflight == 0 {
+		return func() {}, nil
+	}
+
+	slots := r.slots(server)
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (r *Resolver) tryAcquire(server string) (func(), bool) {
+	if r.maxInflight == 0 {
+		return func() {}, true
+	}
+
+	slots := r.slots(server)
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	default:
+		return nil, false
+	}
+}
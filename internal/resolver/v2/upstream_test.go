@@ -0,0 +1,397 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer serves handler over UDP and TCP on a random loopback port and
+// returns the address of the server.
+func startServer(t *testing.T, handler dns.Handler) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	listener, err := net.Listen("tcp", pc.LocalAddr().String())
+	require.Nil(t, err)
+
+	serve(t, &dns.Server{PacketConn: pc, Handler: handler})
+	serve(t, &dns.Server{Listener: listener, Handler: handler})
+
+	return pc.LocalAddr().String()
+}
+
+func serve(t *testing.T, server *dns.Server) {
+	t.Helper()
+
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	<-started
+
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+}
+
+// startSilentServer returns the address of a UDP socket that never replies.
+func startSilentServer(t *testing.T) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	t.Cleanup(func() {
+		_ = pc.Close()
+	})
+
+	return pc.LocalAddr().String()
+}
+
+// zoneHandler answers from records given in zone file format like a
+// recursive server: CNAME chains are followed, wildcard records answer names
+// with no records of their own and unknown names get NXDOMAIN.
+func zoneHandler(t *testing.T, records ...string) dns.HandlerFunc {
+	t.Helper()
+
+	zone := make([]dns.RR, 0, len(records))
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		require.Nil(t, err)
+		zone = append(zone, rr)
+	}
+
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(req)
+
+		question := req.Question[0]
+		name := question.Name
+
+		for range 8 {
+			msg.Rcode = dns.RcodeNameError
+			cname := ""
+
+			for _, rr := range zone {
+				if !strings.EqualFold(rr.Header().Name, name) {
+					continue
+				}
+
+				msg.Rcode = dns.RcodeSuccess
+				if rr.Header().Rrtype == question.Qtype {
+					msg.Answer = append(msg.Answer, rr)
+				} else if target, ok := rr.(*dns.CNAME); ok {
+					msg.Answer = append(msg.Answer, rr)
+					cname = target.Target
+				}
+			}
+
+			if msg.Rcode == dns.RcodeNameError {
+				cname = synthesize(zone, name, question.Qtype, msg)
+			}
+
+			if cname == "" {
+				break
+			}
+			name = cname
+		}
+
+		_ = w.WriteMsg(msg)
+	}
+}
+
+// synthesize answers name from the closest wildcard of its ancestors and
+// returns the target of a synthesized CNAME.
+func synthesize(zone []dns.RR, name string, qtype uint16, msg *dns.Msg) string {
+	for offset, end := dns.NextLabel(name, 0); !end; offset, end = dns.NextLabel(name, offset) {
+		wildcard := "*." + name[offset:]
+		cname := ""
+
+		for _, rr := range zone {
+			if !strings.EqualFold(rr.Header().Name, wildcard) {
+				continue
+			}
+
+			msg.Rcode = dns.RcodeSuccess
+			if rr.Header().Rrtype != qtype && rr.Header().Rrtype != dns.TypeCNAME {
+				continue
+			}
+
+			rr = dns.Copy(rr)
+			rr.Header().Name = name
+			msg.Answer = append(msg.Answer, rr)
+
+			if target, ok := rr.(*dns.CNAME); ok && qtype != dns.TypeCNAME {
+				cname = target.Target
+			}
+		}
+
+		if msg.Rcode == dns.RcodeSuccess {
+			return cname
+		}
+	}
+
+	return ""
+}
+
+func TestParseServer(t *testing.T) {
+	valid := map[string]string{
+		"1.1.1.1":             "1.1.1.1:53",
+		"1.1.1.1:5353":        "1.1.1.1:5353",
+		"::1":                 "[::1]:53",
+		"[::1]":               "[::1]:53",
+		"[2001:db8::1]:5353":  "[2001:db8::1]:5353",
+		"dns.example.com":     "dns.example.com:53",
+		"dns.example.com:853": "dns.example.com:853",
+		"tls://1.1.1.1":       "tls://1.1.1.1:853",
+		"tls://[::1]:8853":    "tls://[::1]:8853",
+		"tls://dns.example":   "tls://dns.example:853",
+	}
+
+	for input, expected := range valid {
+		actual, err := ParseServer(input)
+		require.Nil(t, err, input)
+		require.Equal(t, expected, actual)
+	}
+
+	invalid := []string{
+		"",
+		"1.1.1.1:",
+		"1.1.1.1:0",
+		"1.1.1.1:65536",
+		"1.1.1.1:dns",
+		"foo:bar:baz",
+		"invalid$name",
+		"tls://",
+		"udp://1.1.1.1",
+	}
+
+	for _, input := range invalid {
+		_, err := ParseServer(input)
+		require.NotNil(t, err, input)
+	}
+}
+
+func TestServers(t *testing.T) {
+	server := startServer(t, zoneHandler(t,
+		"internal.example. 60 IN A 10.0.0.1",
+		"internal.example. 60 IN AAAA fd00::1",
+	))
+
+	r := NewResolver().WithServers([]string{server})
+
+	response, err := r.Resolve(context.Background(), []string{"internal.example", "missing.example"})
+	require.Nil(t, err)
+
+	require.Equal(t, []Response{
+		{
+			Name:      "internal.example",
+			Addresses: []string{"10.0.0.1"},
+			Server:    server,
+			Rcode:     "NOERROR",
+			TTLMin:    uint32Ptr(60),
+			TTLMax:    uint32Ptr(60),
+		},
+		{
+			Name:      "missing.example",
+			Addresses: []string{},
+			Server:    server,
+			Rcode:     "NXDOMAIN",
+		},
+	}, stripTiming(response))
+
+	r.resolvConf = "/this/file/does/not/exist"
+	r.WithServers(nil)
+	_, err = r.Resolve(context.Background(), []string{"internal.example"})
+	require.NotNil(t, err)
+}
+
+func TestFailover(t *testing.T) {
+	silent := startSilentServer(t)
+	server := startServer(t, zoneHandler(t, "internal.example. 60 IN A 10.0.0.1"))
+
+	r := NewResolver().
+		WithServers([]string{silent, server}).
+		WithTimeout(100 * time.Millisecond).
+		WithAttempts(1)
+
+	response, err := r.Resolve(context.Background(), []string{"internal.example"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, response[0].Addresses)
+	require.Equal(t, server, response[0].Server)
+
+	refused := startServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetRcode(req, dns.RcodeRefused)
+		_ = w.WriteMsg(msg)
+	}))
+
+	r.WithServers([]string{refused, server})
+
+	response, err = r.Resolve(context.Background(), []string{"internal.example"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, response[0].Addresses)
+	require.Equal(t, server, response[0].Server)
+
+	r.WithServers([]string{refused, silent})
+
+	response, err = r.Resolve(context.Background(), []string{"internal.example"})
+	require.Nil(t, err)
+	require.Equal(t, "REFUSED", response[0].Rcode)
+	require.Equal(t, refused, response[0].Server)
+
+	r.WithServers([]string{silent}).
+		WithAttempts(2).
+		WithBackoff(10 * time.Millisecond)
+
+	start := time.Now()
+	_, err = r.Resolve(context.Background(), []string{"internal.example"})
+	require.NotNil(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 210*time.Millisecond)
+}
+
+func TestCancel(t *testing.T) {
+	silent := startSilentServer(t)
+
+	r := NewResolver().
+		WithServers([]string{silent}).
+		WithTimeout(5 * time.Second).
+		WithConcurrency(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := r.Resolve(ctx, []string{"a.example", "b.example", "c.example"})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start = time.Now()
+	_, err = r.Resolve(ctx, []string{"a.example"})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestRotate(t *testing.T) {
+	first := startServer(t, zoneHandler(t, "internal.example. 60 IN A 10.0.0.1"))
+	second := startServer(t, zoneHandler(t, "internal.example. 60 IN A 10.0.0.1"))
+
+	r := NewResolver().
+		WithServers([]string{first, second}).
+		WithRotate(true)
+
+	response, err := r.Resolve(context.Background(), []string{"internal.example", "internal.example", "internal.example"})
+	require.Nil(t, err)
+
+	servers := make([]string, 0)
+	for _, r := range response {
+		servers = append(servers, r.Server)
+	}
+	require.Equal(t, []string{first, second, first}, servers)
+
+	r.WithRotate(false)
+	response, err = r.Resolve(context.Background(), []string{"internal.example", "internal.example"})
+	require.Nil(t, err)
+	require.Equal(t, first, response[0].Server)
+	require.Equal(t, first, response[1].Server)
+}
+
+func TestResolvConfOptions(t *testing.T) {
+	resolvConf := path.Join(t.TempDir(), "resolv.conf")
+	err := os.WriteFile(resolvConf, []byte(strings.Join([]string{
+		"nameserver 192.0.2.1",
+		"nameserver 2001:db8::1",
+		"options timeout:3 attempts:4 rotate",
+	}, "\n")), 0o644)
+	require.Nil(t, err)
+
+	r := NewResolver()
+	r.resolvConf = resolvConf
+
+	u, err := r.upstreams()
+	require.Nil(t, err)
+	require.Equal(t, []string{"192.0.2.1:53", "[2001:db8::1]:53"}, u.servers)
+	require.Equal(t, 4, u.attempts)
+	require.Equal(t, 3*time.Second, u.client.Timeout)
+	require.True(t, u.rotate)
+
+	r.WithAttempts(1).WithTimeout(time.Second)
+
+	u, err = r.upstreams()
+	require.Nil(t, err)
+	require.Equal(t, 1, u.attempts)
+	require.Equal(t, time.Second, u.client.Timeout)
+
+	r.WithServers([]string{"192.0.2.53:53"}).WithAttempts(0)
+
+	u, err = r.upstreams()
+	require.Nil(t, err)
+	require.Equal(t, []string{"192.0.2.53:53"}, u.servers)
+	require.Equal(t, AttemptsDefault, u.attempts)
+	require.False(t, u.rotate)
+}
+
+func TestTruncated(t *testing.T) {
+	zone := zoneHandler(t,
+		"cdn.example. 60 IN A 10.0.0.1",
+		"cdn.example. 60 IN A 10.0.0.2",
+		"cdn.example. 60 IN A 10.0.0.3",
+	)
+
+	var mu sync.Mutex
+	networks := make([]string, 0)
+	server := startServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		mu.Lock()
+		networks = append(networks, w.RemoteAddr().Network())
+		mu.Unlock()
+
+		if w.RemoteAddr().Network() == "tcp" {
+			zone(w, req)
+			return
+		}
+
+		msg := new(dns.Msg)
+		msg.SetReply(req)
+		msg.Truncated = true
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(10, 0, 0, 1),
+		})
+		_ = w.WriteMsg(msg)
+	}))
+
+	r := NewResolver().WithServers([]string{server})
+
+	response, err := r.Resolve(context.Background(), []string{"cdn.example"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, response[0].Addresses)
+	require.Equal(t, []string{"udp", "tcp"}, networks)
+
+	networks = networks[:0]
+	r.WithTransport(TransportTCP)
+
+	response, err = r.Resolve(context.Background(), []string{"cdn.example"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, response[0].Addresses)
+	require.Equal(t, []string{"tcp"}, networks)
+}
+
+func uint32Ptr(i uint32) *uint32 {
+	return &i
+}
@@ -0,0 +1,85 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// wildcardProbe keeps values a random name of a zone resolves to, so every
+// zone is probed once per call no matter how many of its names are resolved.
+type wildcardProbe struct {
+	once   sync.Once
+	values []string
+	err    error
+}
+
+// WithWildcard enables detection of names answered by a wildcard record of
+// their parent zone; such responses get the Wildcard flag.
+func (r *Resolver) WithWildcard(w bool) *Resolver {
+	r.wildcard = w
+	return r
+}
+
+// checkWildcard resolves a random label under the parent of fqdn and flags the
+// response when the probe gets the very same records. Names with no parent
+// and failed probes are left as they are.
+func (r *Resolver) checkWildcard(ctx context.Context, u *upstreams, response *Response, fqdn string) error {
+	if !r.wildcard || response.Rcode != dns.RcodeToString[dns.RcodeSuccess] {
+		return nil
+	}
+
+	values := response.Values()
+	if len(values) == 0 {
+		return nil
+	}
+
+	offset, end := dns.NextLabel(fqdn, 0)
+	if end || offset >= len(fqdn)-1 {
+		return nil
+	}
+
+	probe := u.wildcardProbe(fqdn[offset:])
+	probe.once.Do(func() {
+		probe.values, probe.err = r.probeWildcard(ctx, u, fqdn[offset:])
+	})
+
+	if probe.err != nil {
+		return ctx.Err()
+	}
+
+	response.Wildcard = slices.Equal(values, probe.values)
+	return nil
+}
+
+func (r *Resolver) probeWildcard(ctx context.Context, u *upstreams, parent string) ([]string, error) {
+	name := fmt.Sprintf("%016x.%s", rand.Uint64(), parent)
+
+	response, _, err := r.resolveFQDN(ctx, name, name, u)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Rcode != dns.RcodeToString[dns.RcodeSuccess] {
+		return nil, nil
+	}
+
+	return response.Values(), nil
+}
+
+func (u *upstreams) wildcardProbe(parent string) *wildcardProbe {
+	u.wildcardsMu.Lock()
+	defer u.wildcardsMu.Unlock()
+
+	probe, ok := u.wildcards[parent]
+	if !ok {
+		probe = &wildcardProbe{}
+		u.wildcards[parent] = probe
+	}
+
+	return probe
+}
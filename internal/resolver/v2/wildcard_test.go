@@ -0,0 +1,70 @@
+package resolver
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWildcard(t *testing.T) {
+	zone := zoneHandler(t,
+		"*.wild.example. 60 IN A 10.0.0.9",
+		"api.wild.example. 60 IN A 10.0.0.1",
+		"plain.example. 60 IN A 10.0.0.2",
+	)
+
+	var mu sync.Mutex
+	probes := make([]string, 0)
+	server := startServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		name := req.Question[0].Name
+		if label, _, _ := strings.Cut(name, "."); len(label) == 16 {
+			mu.Lock()
+			probes = append(probes, strings.TrimPrefix(name, label+"."))
+			mu.Unlock()
+		}
+
+		zone(w, req)
+	}))
+
+	r := NewResolver().
+		WithServers([]string{server}).
+		WithMode(ModeIpv4).
+		WithSearch(false)
+
+	names := []string{"foo.wild.example", "api.wild.example", "bar.wild.example", "plain.example", "missing.example"}
+
+	response, err := r.Resolve(context.Background(), names)
+	require.Nil(t, err)
+	for _, response := range response {
+		require.False(t, response.Wildcard, response.Name)
+	}
+	mu.Lock()
+	require.Empty(t, probes)
+	mu.Unlock()
+
+	r.WithWildcard(true)
+
+	response, err = r.Resolve(context.Background(), names)
+	require.Nil(t, err)
+
+	wildcard := make(map[string]bool)
+	for _, response := range response {
+		wildcard[response.Name] = response.Wildcard
+	}
+
+	require.Equal(t, map[string]bool{
+		"foo.wild.example": true,
+		"api.wild.example": false,
+		"bar.wild.example": true,
+		"plain.example":    false,
+		"missing.example":  false,
+	}, wildcard)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.ElementsMatch(t, []string{"wild.example.", "example."}, probes)
+}